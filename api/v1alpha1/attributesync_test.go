@@ -2,6 +2,7 @@ package v1alpha1_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
@@ -30,6 +31,26 @@ func TestAttributeSync_GetCaSecret(t *testing.T) {
 	})
 }
 
+func TestAttributeSync_GetClientCertSecret(t *testing.T) {
+	nsName := "myapp"
+	subject := &v1alpha1.AttributeSync{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: nsName,
+		},
+	}
+	t.Run("returns nil if empty", func(t *testing.T) {
+		assert.Nil(t, subject.GetClientCertSecret())
+	})
+	t.Run("returns default if reference is set and namespace is empty", func(t *testing.T) {
+		subject.Spec.ClientCertSecret = &corev1.SecretReference{}
+		assert.Equal(t, nsName, subject.GetClientCertSecret().Namespace)
+	})
+	t.Run("returns namespace if set", func(t *testing.T) {
+		subject.Spec.ClientCertSecret.Namespace = "override"
+		assert.Equal(t, "override", subject.GetClientCertSecret().Namespace)
+	})
+}
+
 func TestAttributeSync_GetCredentialsSecret(t *testing.T) {
 	nsName := "myapp"
 	subject := &v1alpha1.AttributeSync{
@@ -56,3 +77,67 @@ func TestAttributeSync_GetLoginRealm(t *testing.T) {
 		assert.Equal(t, "override", subject.GetLoginRealm())
 	})
 }
+
+func TestAttributeSync_GetAdminEventsPollInterval(t *testing.T) {
+	subject := &v1alpha1.AttributeSync{}
+	t.Run("returns zero if admin events are disabled", func(t *testing.T) {
+		assert.Equal(t, time.Duration(0), subject.GetAdminEventsPollInterval())
+	})
+	t.Run("returns default if poll interval is empty", func(t *testing.T) {
+		subject.Spec.AdminEvents = &v1alpha1.AdminEventsSpec{}
+		assert.Equal(t, 30*time.Second, subject.GetAdminEventsPollInterval())
+	})
+	t.Run("returns poll interval if set", func(t *testing.T) {
+		subject.Spec.AdminEvents.PollInterval = &metav1.Duration{Duration: time.Minute}
+		assert.Equal(t, time.Minute, subject.GetAdminEventsPollInterval())
+	})
+}
+
+func TestAttributeSync_GetHTTPTimeout(t *testing.T) {
+	subject := &v1alpha1.AttributeSync{}
+	t.Run("returns default if http is not configured", func(t *testing.T) {
+		assert.Equal(t, 30*time.Second, subject.GetHTTPTimeout())
+	})
+	t.Run("returns default if timeout is not set", func(t *testing.T) {
+		subject.Spec.HTTP = &v1alpha1.HTTPSpec{}
+		assert.Equal(t, 30*time.Second, subject.GetHTTPTimeout())
+	})
+	t.Run("returns timeout if set", func(t *testing.T) {
+		subject.Spec.HTTP.Timeout = &metav1.Duration{Duration: time.Minute}
+		assert.Equal(t, time.Minute, subject.GetHTTPTimeout())
+	})
+}
+
+func TestAttributeSync_GetHTTPRetries(t *testing.T) {
+	subject := &v1alpha1.AttributeSync{}
+	t.Run("returns default if http is not configured", func(t *testing.T) {
+		assert.Equal(t, 3, subject.GetHTTPRetries())
+	})
+	t.Run("returns retries if set to zero", func(t *testing.T) {
+		retries := 0
+		subject.Spec.HTTP = &v1alpha1.HTTPSpec{Retries: &retries}
+		assert.Equal(t, 0, subject.GetHTTPRetries())
+	})
+}
+
+func TestAttributeSync_GetFullSyncInterval(t *testing.T) {
+	subject := &v1alpha1.AttributeSync{}
+	t.Run("returns default if incremental sync is not configured", func(t *testing.T) {
+		assert.Equal(t, 24*time.Hour, subject.GetFullSyncInterval())
+	})
+	t.Run("returns full sync interval if set", func(t *testing.T) {
+		subject.Spec.Incremental = &v1alpha1.IncrementalSpec{FullSyncInterval: &metav1.Duration{Duration: time.Hour}}
+		assert.Equal(t, time.Hour, subject.GetFullSyncInterval())
+	})
+}
+
+func TestAttributeSync_GetReportMaxUsers(t *testing.T) {
+	subject := &v1alpha1.AttributeSync{}
+	t.Run("returns default if report is not configured", func(t *testing.T) {
+		assert.Equal(t, 1000, subject.GetReportMaxUsers())
+	})
+	t.Run("returns max users if set", func(t *testing.T) {
+		subject.Spec.Report = &v1alpha1.ReportSpec{MaxUsers: 10}
+		assert.Equal(t, 10, subject.GetReportMaxUsers())
+	})
+}
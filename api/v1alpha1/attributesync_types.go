@@ -1,6 +1,8 @@
 package v1alpha1
 
 import (
+	"time"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -10,13 +12,28 @@ import (
 
 // AttributeSyncSpec defines the desired state of AttributeSync
 type AttributeSyncSpec struct {
+	// ConnectionRef is a reference to a KeycloakConnection holding the connection details of the Keycloak server.
+	// If set, the URL, LoginRealm, CredentialsSecret, CaSecret, ClientCertSecret and HTTP fields are ignored.
+	// +kubebuilder:validation:Optional
+	ConnectionRef *ConnectionReference `json:"connectionRef,omitempty"`
+
 	// CaSecret is a reference to a secret containing a CA certificate to communicate to the Keycloak server
 	// +kubebuilder:validation:Optional
 	CaSecret *corev1.SecretReference `json:"caSecret,omitempty"`
 
-	// CredentialsSecret is a reference to a secret containing authentication details for the Keycloak server
-	// +kubebuilder:validation:Required
-	CredentialsSecret corev1.SecretReference `json:"credentialsSecret"`
+	// ClientCertSecret is a reference to a secret containing a client certificate and key to authenticate to the Keycloak server.
+	// If not set, a client certificate in the CaSecret is used.
+	// +kubebuilder:validation:Optional
+	ClientCertSecret *corev1.SecretReference `json:"clientCertSecret,omitempty"`
+
+	// HTTP configures the HTTP client used to connect to the Keycloak server
+	// +kubebuilder:validation:Optional
+	HTTP *HTTPSpec `json:"http,omitempty"`
+
+	// CredentialsSecret is a reference to a secret containing authentication details for the Keycloak server.
+	// Required unless ConnectionRef is set.
+	// +kubebuilder:validation:Optional
+	CredentialsSecret corev1.SecretReference `json:"credentialsSecret,omitempty"`
 
 	// LoginRealm is the Keycloak realm to authenticate against
 	// +kubebuilder:validation:Optional
@@ -26,9 +43,9 @@ type AttributeSyncSpec struct {
 	// +kubebuilder:validation:Required
 	Realm string `json:"realm"`
 
-	// URL is the location of the Keycloak server
-	// +kubebuilder:validation:Required
-	URL string `json:"url"`
+	// URL is the location of the Keycloak server. Required unless ConnectionRef is set.
+	// +kubebuilder:validation:Optional
+	URL string `json:"url,omitempty"`
 
 	// Attribute specifies the attribute to sync
 	// +kubebuilder:validation:Required
@@ -42,15 +59,181 @@ type AttributeSyncSpec struct {
 	// +kubebuilder:validation:Optional
 	TargetAnnotation string `json:"targetAnnotation,omitempty"`
 
-	// Schedule represents a cron based configuration for synchronization
+	// ReverseSync writes a label or annotation of the OpenShift users back to a Keycloak user attribute on every full synchronization
+	// +kubebuilder:validation:Optional
+	ReverseSync *ReverseSyncSpec `json:"reverseSync,omitempty"`
+
+	// Schedule represents a cron based configuration for synchronization.
+	// The time zone can be set with a `CRON_TZ=<zone>` prefix, it defaults to the time zone of the controller.
 	// +kubebuilder:validation:Optional
 	Schedule string `json:"schedule,omitempty"`
+
+	// ScheduleJitter is the maximum delay added to every scheduled synchronization to spread many AttributeSyncs with the same schedule.
+	// The delay is derived from the namespace and name, so it stays the same for every run.
+	// +kubebuilder:validation:Optional
+	ScheduleJitter *metav1.Duration `json:"scheduleJitter,omitempty"`
+
+	// StartingDeadline skips scheduled synchronizations missed by more than the deadline, for example while the controller was down.
+	// If not set, missed synchronizations are caught up with a single synchronization.
+	// +kubebuilder:validation:Optional
+	StartingDeadline *metav1.Duration `json:"startingDeadline,omitempty"`
+
+	// Suspend stops all synchronizations until it is unset, for example during a migration of the Keycloak server.
+	// Synchronizations requested with the sync-now annotation are not run while suspended.
+	// +kubebuilder:validation:Optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// AdminEvents enables polling the admin events of the realm for user updates.
+	// Updated users are synced immediately, the Schedule still triggers a full synchronization.
+	// +kubebuilder:validation:Optional
+	AdminEvents *AdminEventsSpec `json:"adminEvents,omitempty"`
+
+	// Incremental only updates users whose attribute changed since they were last synced.
+	// The fingerprints of the synced values are stored in the ConfigMap `<name>-fingerprints`.
+	// +kubebuilder:validation:Optional
+	Incremental *IncrementalSpec `json:"incremental,omitempty"`
+
+	// DryRun computes the changes a synchronization would make without updating any OpenShift user.
+	// The planned changes are recorded in the status.
+	// +kubebuilder:validation:Optional
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// DeletionPolicy defines whether the synced labels and annotations are kept on the OpenShift users when the AttributeSync is deleted.
+	// `Retain` keeps them, `Remove` removes them from all users before the AttributeSync is deleted. Defaults to `Retain`.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Retain;Remove
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+
+	// Report enables writing the outcome of every full synchronization per user to the ConfigMap `<name>-report`.
+	// +kubebuilder:validation:Optional
+	Report *ReportSpec `json:"report,omitempty"`
+}
+
+// SyncNowAnnotation requests an immediate full synchronization of an AttributeSync or ClusterAttributeSync.
+// A synchronization is run whenever the value differs from the LastHandledSyncNow of the status, for example a timestamp.
+const SyncNowAnnotation = "attributesync.keycloak.appuio.io/sync-now"
+
+// ShardLabel assigns an AttributeSync, ClusterAttributeSync or KeycloakConnection to a shard if the controller runs sharded.
+// The value is the number of the shard, objects without a valid label are assigned by the hash of their namespace and name.
+const ShardLabel = "attributesync.keycloak.appuio.io/shard"
+
+const (
+	// DeletionPolicyRetain keeps the synced labels and annotations when an AttributeSync is deleted
+	DeletionPolicyRetain = "Retain"
+	// DeletionPolicyRemove removes the synced labels and annotations from all OpenShift users when an AttributeSync is deleted
+	DeletionPolicyRemove = "Remove"
+)
+
+// HTTPSpec configures the HTTP client used to connect to Keycloak
+type HTTPSpec struct {
+	// Timeout is the timeout of a single request to Keycloak. All users are listed in pages of 500 users, each with its own timeout. Defaults to 30s.
+	// +kubebuilder:validation:Optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// ProxyURL is the URL of the HTTP(S) proxy to connect through. The scheme must be http, https or socks5.
+	// If not set, the proxy is read from the environment variables `HTTPS_PROXY`, `HTTP_PROXY` and `NO_PROXY` of the controller.
+	// +kubebuilder:validation:Optional
+	ProxyURL string `json:"proxyURL,omitempty"`
+
+	// Retries is the number of times a request failing with a network error, a 5xx or a 429 response is retried. Defaults to 3.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	Retries *int `json:"retries,omitempty"`
+
+	// Headers are added to every request to Keycloak, for example to authenticate to an API gateway
+	// +kubebuilder:validation:Optional
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// ReverseSyncSpec configures the synchronization from OpenShift users to Keycloak
+type ReverseSyncSpec struct {
+	// SourceLabel specifies the label to read the value from. Exactly one of SourceLabel and SourceAnnotation must be set.
+	// +kubebuilder:validation:Optional
+	SourceLabel string `json:"sourceLabel,omitempty"`
+
+	// SourceAnnotation specifies the annotation to read the value from. Exactly one of SourceLabel and SourceAnnotation must be set.
+	// +kubebuilder:validation:Optional
+	SourceAnnotation string `json:"sourceAnnotation,omitempty"`
+
+	// Attribute specifies the Keycloak user attribute to write the value to
+	// +kubebuilder:validation:Required
+	Attribute string `json:"attribute"`
+}
+
+// AdminEventsSpec configures the event-driven synchronization
+type AdminEventsSpec struct {
+	// PollInterval is the interval in which admin events are fetched from Keycloak. Defaults to 30s.
+	// +kubebuilder:validation:Optional
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+}
+
+// IncrementalSpec configures the incremental synchronization
+type IncrementalSpec struct {
+	// FullSyncInterval is the interval in which all users are updated regardless of their fingerprint to correct drift. Defaults to 24h.
+	// +kubebuilder:validation:Optional
+	FullSyncInterval *metav1.Duration `json:"fullSyncInterval,omitempty"`
+}
+
+// ReportSpec configures the synchronization report
+type ReportSpec struct {
+	// MaxUsers is the maximum number of users listed in the report. Defaults to 1000.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=5000
+	MaxUsers int `json:"maxUsers,omitempty"`
 }
 
 // AttributeSyncStatus defines the observed state of AttributeSync
 type AttributeSyncStatus struct {
 	// +kubebuilder:validation:Optional
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// LastSyncTime is the time of the last successful full synchronization
+	// +kubebuilder:validation:Optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// LastAdminEventTime is the time of the newest processed admin event
+	// +kubebuilder:validation:Optional
+	LastAdminEventTime *metav1.Time `json:"lastAdminEventTime,omitempty"`
+
+	// LastFullSyncTime is the time of the last synchronization updating all users regardless of their fingerprint
+	// +kubebuilder:validation:Optional
+	LastFullSyncTime *metav1.Time `json:"lastFullSyncTime,omitempty"`
+
+	// PlannedChanges lists the changes the last dry run would have made, truncated to the first 100 entries
+	// +kubebuilder:validation:Optional
+	PlannedChanges []PlannedChange `json:"plannedChanges,omitempty"`
+
+	// PlannedChangesCount is the total number of changes the last dry run would have made
+	// +kubebuilder:validation:Optional
+	PlannedChangesCount int `json:"plannedChangesCount,omitempty"`
+
+	// LastHandledSyncNow is the value of the sync-now annotation of the last requested synchronization that was run
+	// +kubebuilder:validation:Optional
+	LastHandledSyncNow string `json:"lastHandledSyncNow,omitempty"`
+
+	// ReferencesHash is a hash of the versions of the KeycloakConnection and secrets referenced at the last full synchronization
+	// +kubebuilder:validation:Optional
+	ReferencesHash string `json:"referencesHash,omitempty"`
+}
+
+// PlannedChange is a change to a label or annotation of an OpenShift user found by a dry run
+type PlannedChange struct {
+	// User is the name of the OpenShift user
+	User string `json:"user"`
+	// Kind is either `Label` or `Annotation`
+	Kind string `json:"kind"`
+	// Key is the key of the label or annotation
+	Key string `json:"key"`
+	// OldValue is the current value, empty if the key is not set
+	// +kubebuilder:validation:Optional
+	OldValue string `json:"oldValue,omitempty"`
+	// NewValue is the value from Keycloak, empty if the key would be removed
+	// +kubebuilder:validation:Optional
+	NewValue string `json:"newValue,omitempty"`
+	// Action is one of `Add`, `Update` or `Remove`
+	Action string `json:"action"`
 }
 
 //+kubebuilder:object:root=true
@@ -74,32 +257,103 @@ type AttributeSyncList struct {
 	Items           []AttributeSync `json:"items"`
 }
 
-func (a *AttributeSync) GetCaSecret() *corev1.SecretReference {
-	ref := a.Spec.CaSecret
-	if ref == nil {
-		return nil
+// InlineConnection returns the connection details set directly in the spec.
+// Secret references without a namespace default to the given namespace.
+func (s *AttributeSyncSpec) InlineConnection(namespace string) *KeycloakConnection {
+	return &KeycloakConnection{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace},
+		Spec: KeycloakConnectionSpec{
+			CaSecret:          s.CaSecret,
+			ClientCertSecret:  s.ClientCertSecret,
+			HTTP:              s.HTTP,
+			CredentialsSecret: s.CredentialsSecret,
+			LoginRealm:        s.LoginRealm,
+			URL:               s.URL,
+		},
+	}
+}
+
+func (s *AttributeSyncSpec) adminEventsPollInterval() time.Duration {
+	if s.AdminEvents == nil {
+		return 0
 	}
-	ns := ref.Namespace
-	if ns == "" {
-		ns = a.ObjectMeta.Namespace
+	if s.AdminEvents.PollInterval == nil || s.AdminEvents.PollInterval.Duration <= 0 {
+		return 30 * time.Second
 	}
-	return &corev1.SecretReference{Name: ref.Name, Namespace: ns}
+	return s.AdminEvents.PollInterval.Duration
 }
 
-func (a *AttributeSync) GetCredentialsSecret() corev1.SecretReference {
-	ref := a.Spec.CredentialsSecret
-	ns := ref.Namespace
-	if ns == "" {
-		ns = a.ObjectMeta.Namespace
+func (s *AttributeSyncSpec) fullSyncInterval() time.Duration {
+	if s.Incremental == nil || s.Incremental.FullSyncInterval == nil || s.Incremental.FullSyncInterval.Duration <= 0 {
+		return 24 * time.Hour
 	}
-	return corev1.SecretReference{Name: ref.Name, Namespace: ns}
+	return s.Incremental.FullSyncInterval.Duration
 }
 
-func (a *AttributeSync) GetLoginRealm() string {
-	if a.Spec.LoginRealm == "" {
-		return "master"
+func (s *AttributeSyncSpec) reportMaxUsers() int {
+	if s.Report == nil || s.Report.MaxUsers <= 0 {
+		return 1000
 	}
-	return a.Spec.LoginRealm
+	return s.Report.MaxUsers
+}
+
+func (a *AttributeSync) GetCaSecret() *corev1.SecretReference {
+	return a.Spec.InlineConnection(a.Namespace).GetCaSecret()
+}
+
+func (a *AttributeSync) GetClientCertSecret() *corev1.SecretReference {
+	return a.Spec.InlineConnection(a.Namespace).GetClientCertSecret()
+}
+
+func (a *AttributeSync) GetCredentialsSecret() corev1.SecretReference {
+	return a.Spec.InlineConnection(a.Namespace).GetCredentialsSecret()
+}
+
+func (a *AttributeSync) GetLoginRealm() string {
+	return a.Spec.InlineConnection(a.Namespace).GetLoginRealm()
+}
+
+// GetHTTPTimeout returns the timeout of a single request to Keycloak.
+func (a *AttributeSync) GetHTTPTimeout() time.Duration {
+	return a.Spec.InlineConnection(a.Namespace).GetHTTPTimeout()
+}
+
+// GetHTTPRetries returns the number of times a failed request to Keycloak is retried.
+func (a *AttributeSync) GetHTTPRetries() int {
+	return a.Spec.InlineConnection(a.Namespace).GetHTTPRetries()
+}
+
+// GetAdminEventsPollInterval returns the interval in which admin events are polled, or zero if admin events are disabled.
+func (a *AttributeSync) GetAdminEventsPollInterval() time.Duration {
+	return a.Spec.adminEventsPollInterval()
+}
+
+// GetFullSyncInterval returns the interval in which incremental synchronizations update all users.
+func (a *AttributeSync) GetFullSyncInterval() time.Duration {
+	return a.Spec.fullSyncInterval()
+}
+
+// GetFingerprintsConfigMapName returns the name of the ConfigMap storing the fingerprints of an incremental synchronization.
+func (a *AttributeSync) GetFingerprintsConfigMapName() string {
+	return a.ObjectMeta.Name + "-fingerprints"
+}
+
+// GetReportConfigMapName returns the name of the ConfigMap storing the synchronization report.
+func (a *AttributeSync) GetReportConfigMapName() string {
+	return a.ObjectMeta.Name + "-report"
+}
+
+// GetReportMaxUsers returns the maximum number of users listed in the synchronization report.
+func (a *AttributeSync) GetReportMaxUsers() int {
+	return a.Spec.reportMaxUsers()
+}
+
+func (a *AttributeSync) GetSpec() *AttributeSyncSpec {
+	return &a.Spec
+}
+
+func (a *AttributeSync) GetStatus() *AttributeSyncStatus {
+	return &a.Status
 }
 
 func (a *AttributeSync) GetConditions() []metav1.Condition {
@@ -0,0 +1,30 @@
+package v1alpha1
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AttributeSyncObject is implemented by AttributeSync and ClusterAttributeSync, which share their spec and status.
+// +kubebuilder:object:generate=false
+type AttributeSyncObject interface {
+	client.Object
+
+	GetSpec() *AttributeSyncSpec
+	GetStatus() *AttributeSyncStatus
+	GetConditions() []metav1.Condition
+	SetConditions(conditions []metav1.Condition)
+
+	GetAdminEventsPollInterval() time.Duration
+	GetFullSyncInterval() time.Duration
+	GetFingerprintsConfigMapName() string
+	GetReportConfigMapName() string
+	GetReportMaxUsers() int
+}
+
+var (
+	_ AttributeSyncObject = &AttributeSync{}
+	_ AttributeSyncObject = &ClusterAttributeSync{}
+)
@@ -0,0 +1,29 @@
+package v1alpha1_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+)
+
+func TestClusterAttributeSync_GetReportConfigMapName(t *testing.T) {
+	subject := &v1alpha1.ClusterAttributeSync{ObjectMeta: metav1.ObjectMeta{Name: "sync"}}
+	assert.Equal(t, "sync.report", subject.GetReportConfigMapName())
+	assert.Equal(t, "sync.fingerprints", subject.GetFingerprintsConfigMapName())
+}
+
+func TestClusterAttributeSync_ConfigMapNamesDontCollide(t *testing.T) {
+	cluster := &v1alpha1.ClusterAttributeSync{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}
+	clusterNames := []string{cluster.GetReportConfigMapName(), cluster.GetFingerprintsConfigMapName(), cluster.GetFingerprintsConfigMapName() + "-1"}
+
+	// Names of AttributeSyncs in the cluster resource namespace which could be mistaken for the ClusterAttributeSync
+	for _, name := range []string{"foo", "cluster-foo", "foo.cluster", "foo.report", "foo.fingerprints", "foo.fingerprints-1"} {
+		namespaced := &v1alpha1.AttributeSync{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "sync"}}
+		for _, n := range []string{namespaced.GetReportConfigMapName(), namespaced.GetFingerprintsConfigMapName(), namespaced.GetFingerprintsConfigMapName() + "-1"} {
+			assert.NotContains(t, clusterNames, n, "AttributeSync %q", name)
+		}
+	}
+}
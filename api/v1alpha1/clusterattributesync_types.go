@@ -0,0 +1,79 @@
+package v1alpha1
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+
+// ClusterAttributeSync is the Schema for the clusterattributesyncs API.
+// It is the cluster-scoped variant of AttributeSync. Secret and connection references without a namespace,
+// as well as the fingerprints and report ConfigMaps, use the cluster resource namespace of the controller.
+type ClusterAttributeSync struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AttributeSyncSpec   `json:"spec,omitempty"`
+	Status AttributeSyncStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterAttributeSyncList contains a list of ClusterAttributeSync
+type ClusterAttributeSyncList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterAttributeSync `json:"items"`
+}
+
+// GetAdminEventsPollInterval returns the interval in which admin events are polled, or zero if admin events are disabled.
+func (c *ClusterAttributeSync) GetAdminEventsPollInterval() time.Duration {
+	return c.Spec.adminEventsPollInterval()
+}
+
+// GetFullSyncInterval returns the interval in which incremental synchronizations update all users.
+func (c *ClusterAttributeSync) GetFullSyncInterval() time.Duration {
+	return c.Spec.fullSyncInterval()
+}
+
+// GetFingerprintsConfigMapName returns the name of the ConfigMap storing the fingerprints of an incremental synchronization.
+// The suffix is separated by a dot instead of the dash used by AttributeSyncs, so the name can't collide with
+// the ConfigMaps of an AttributeSync in the cluster resource namespace.
+func (c *ClusterAttributeSync) GetFingerprintsConfigMapName() string {
+	return c.ObjectMeta.Name + ".fingerprints"
+}
+
+// GetReportConfigMapName returns the name of the ConfigMap storing the synchronization report.
+// The suffix is separated by a dot for the same reason as in GetFingerprintsConfigMapName.
+func (c *ClusterAttributeSync) GetReportConfigMapName() string {
+	return c.ObjectMeta.Name + ".report"
+}
+
+// GetReportMaxUsers returns the maximum number of users listed in the synchronization report.
+func (c *ClusterAttributeSync) GetReportMaxUsers() int {
+	return c.Spec.reportMaxUsers()
+}
+
+func (c *ClusterAttributeSync) GetSpec() *AttributeSyncSpec {
+	return &c.Spec
+}
+
+func (c *ClusterAttributeSync) GetStatus() *AttributeSyncStatus {
+	return &c.Status
+}
+
+func (c *ClusterAttributeSync) GetConditions() []metav1.Condition {
+	return c.Status.Conditions
+}
+
+func (c *ClusterAttributeSync) SetConditions(conditions []metav1.Condition) {
+	c.Status.Conditions = conditions
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterAttributeSync{}, &ClusterAttributeSyncList{})
+}
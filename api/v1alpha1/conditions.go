@@ -0,0 +1,40 @@
+package v1alpha1
+
+// Condition types set on AttributeSync objects in addition to the ReconcileSuccess and ReconcileError conditions
+const (
+	// ConditionReady is true if the credentials are valid, Keycloak is reachable and all users were synced
+	ConditionReady = "Ready"
+	// ConditionCredentialsValid is true if the credentials secret exists and authenticating to Keycloak succeeded
+	ConditionCredentialsValid = "CredentialsValid"
+	// ConditionKeycloakReachable is true if the last request to Keycloak succeeded
+	ConditionKeycloakReachable = "KeycloakReachable"
+	// ConditionSynced is true if the last synchronization succeeded
+	ConditionSynced = "Synced"
+	// ConditionDegraded is true if some OpenShift users could not be updated by the last synchronization
+	ConditionDegraded = "Degraded"
+	// ConditionSuspended is true if the synchronization is suspended by the spec
+	ConditionSuspended = "Suspended"
+)
+
+// Condition reasons set on AttributeSync objects
+const (
+	ReasonReady                = "Ready"
+	ReasonCredentialsValid     = "CredentialsValid"
+	ReasonSecretNotFound       = "SecretNotFound"
+	ReasonSecretInvalid        = "SecretInvalid"
+	ReasonAuthenticationFailed = "AuthenticationFailed"
+	ReasonKeycloakReachable    = "KeycloakReachable"
+	ReasonKeycloakUnavailable  = "KeycloakUnavailable"
+	ReasonTLSConfigInvalid     = "TLSConfigInvalid"
+	ReasonTLSFailed            = "TLSFailed"
+	ReasonSynced               = "Synced"
+	ReasonSyncFailed           = "SyncFailed"
+	ReasonInvalidSchedule      = "InvalidSchedule"
+	ReasonReverseSyncConflict  = "ReverseSyncConflict"
+	ReasonConnectionNotFound   = "ConnectionNotFound"
+	ReasonReferenceNotAllowed  = "ReferenceNotAllowed"
+	ReasonUserUpdateFailed     = "UserUpdateFailed"
+	ReasonAllUsersSynced       = "AllUsersSynced"
+	ReasonSuspended            = "Suspended"
+	ReasonNotChecked           = "NotChecked"
+)
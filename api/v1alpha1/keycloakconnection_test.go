@@ -0,0 +1,50 @@
+package v1alpha1_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+)
+
+func TestKeycloakConnection_GetCredentialsSecret(t *testing.T) {
+	nsName := "myapp"
+	subject := &v1alpha1.KeycloakConnection{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: nsName,
+		},
+	}
+	t.Run("returns default if namespace is empty", func(t *testing.T) {
+		assert.Equal(t, nsName, subject.GetCredentialsSecret().Namespace)
+	})
+	t.Run("returns namespace if set", func(t *testing.T) {
+		subject.Spec.CredentialsSecret = corev1.SecretReference{Namespace: "override"}
+		assert.Equal(t, "override", subject.GetCredentialsSecret().Namespace)
+	})
+}
+
+func TestKeycloakConnection_GetCheckInterval(t *testing.T) {
+	subject := &v1alpha1.KeycloakConnection{}
+	t.Run("returns default if empty", func(t *testing.T) {
+		assert.Equal(t, 5*time.Minute, subject.GetCheckInterval())
+	})
+	t.Run("returns check interval if set", func(t *testing.T) {
+		subject.Spec.CheckInterval = &metav1.Duration{Duration: time.Minute}
+		assert.Equal(t, time.Minute, subject.GetCheckInterval())
+	})
+}
+
+func TestAttributeSyncSpec_InlineConnection(t *testing.T) {
+	spec := &v1alpha1.AttributeSyncSpec{
+		URL:               "https://keycloak.example.com",
+		CredentialsSecret: corev1.SecretReference{Name: "creds"},
+	}
+	conn := spec.InlineConnection("myapp")
+	assert.Equal(t, "https://keycloak.example.com", conn.Spec.URL)
+	assert.Equal(t, corev1.SecretReference{Name: "creds", Namespace: "myapp"}, conn.GetCredentialsSecret())
+	assert.Equal(t, "master", conn.GetLoginRealm())
+}
@@ -0,0 +1,159 @@
+package v1alpha1
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KeycloakConnectionSpec defines how to connect and authenticate to a Keycloak server
+type KeycloakConnectionSpec struct {
+	// CaSecret is a reference to a secret containing a CA certificate to communicate to the Keycloak server
+	// +kubebuilder:validation:Optional
+	CaSecret *corev1.SecretReference `json:"caSecret,omitempty"`
+
+	// ClientCertSecret is a reference to a secret containing a client certificate and key to authenticate to the Keycloak server.
+	// If not set, a client certificate in the CaSecret is used.
+	// +kubebuilder:validation:Optional
+	ClientCertSecret *corev1.SecretReference `json:"clientCertSecret,omitempty"`
+
+	// HTTP configures the HTTP client used to connect to the Keycloak server
+	// +kubebuilder:validation:Optional
+	HTTP *HTTPSpec `json:"http,omitempty"`
+
+	// CredentialsSecret is a reference to a secret containing authentication details for the Keycloak server
+	// +kubebuilder:validation:Required
+	CredentialsSecret corev1.SecretReference `json:"credentialsSecret"`
+
+	// LoginRealm is the Keycloak realm to authenticate against
+	// +kubebuilder:validation:Optional
+	LoginRealm string `json:"loginRealm,omitempty"`
+
+	// URL is the location of the Keycloak server
+	// +kubebuilder:validation:Required
+	URL string `json:"url"`
+
+	// CheckInterval is the interval in which the connection to the Keycloak server is checked. Defaults to 5m.
+	// +kubebuilder:validation:Optional
+	CheckInterval *metav1.Duration `json:"checkInterval,omitempty"`
+}
+
+// KeycloakConnectionStatus defines the observed state of KeycloakConnection
+type KeycloakConnectionStatus struct {
+	// +kubebuilder:validation:Optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// LastCheckTime is the time the connection to the Keycloak server was last checked
+	// +kubebuilder:validation:Optional
+	LastCheckTime *metav1.Time `json:"lastCheckTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// KeycloakConnection is the Schema for the keycloakconnections API.
+// It holds the connection details of a Keycloak server shared by several AttributeSync objects.
+type KeycloakConnection struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KeycloakConnectionSpec   `json:"spec,omitempty"`
+	Status KeycloakConnectionStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// KeycloakConnectionList contains a list of KeycloakConnection
+type KeycloakConnectionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KeycloakConnection `json:"items"`
+}
+
+// ConnectionReference references a KeycloakConnection
+type ConnectionReference struct {
+	// Name is the name of the KeycloakConnection
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// Namespace is the namespace of the KeycloakConnection. Defaults to the namespace of the referencing object.
+	// +kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+func (k *KeycloakConnection) GetCaSecret() *corev1.SecretReference {
+	ref := k.Spec.CaSecret
+	if ref == nil {
+		return nil
+	}
+	ns := ref.Namespace
+	if ns == "" {
+		ns = k.ObjectMeta.Namespace
+	}
+	return &corev1.SecretReference{Name: ref.Name, Namespace: ns}
+}
+
+func (k *KeycloakConnection) GetClientCertSecret() *corev1.SecretReference {
+	ref := k.Spec.ClientCertSecret
+	if ref == nil {
+		return nil
+	}
+	ns := ref.Namespace
+	if ns == "" {
+		ns = k.ObjectMeta.Namespace
+	}
+	return &corev1.SecretReference{Name: ref.Name, Namespace: ns}
+}
+
+func (k *KeycloakConnection) GetCredentialsSecret() corev1.SecretReference {
+	ref := k.Spec.CredentialsSecret
+	ns := ref.Namespace
+	if ns == "" {
+		ns = k.ObjectMeta.Namespace
+	}
+	return corev1.SecretReference{Name: ref.Name, Namespace: ns}
+}
+
+func (k *KeycloakConnection) GetLoginRealm() string {
+	if k.Spec.LoginRealm == "" {
+		return "master"
+	}
+	return k.Spec.LoginRealm
+}
+
+// GetHTTPTimeout returns the timeout of a single request to Keycloak.
+func (k *KeycloakConnection) GetHTTPTimeout() time.Duration {
+	if k.Spec.HTTP == nil || k.Spec.HTTP.Timeout == nil || k.Spec.HTTP.Timeout.Duration <= 0 {
+		return 30 * time.Second
+	}
+	return k.Spec.HTTP.Timeout.Duration
+}
+
+// GetHTTPRetries returns the number of times a failed request to Keycloak is retried.
+func (k *KeycloakConnection) GetHTTPRetries() int {
+	if k.Spec.HTTP == nil || k.Spec.HTTP.Retries == nil {
+		return 3
+	}
+	return *k.Spec.HTTP.Retries
+}
+
+// GetCheckInterval returns the interval in which the connection is checked.
+func (k *KeycloakConnection) GetCheckInterval() time.Duration {
+	if k.Spec.CheckInterval == nil || k.Spec.CheckInterval.Duration <= 0 {
+		return 5 * time.Minute
+	}
+	return k.Spec.CheckInterval.Duration
+}
+
+func (k *KeycloakConnection) GetConditions() []metav1.Condition {
+	return k.Status.Conditions
+}
+
+func (k *KeycloakConnection) SetConditions(conditions []metav1.Condition) {
+	k.Status.Conditions = conditions
+}
+
+func init() {
+	SchemeBuilder.Register(&KeycloakConnection{}, &KeycloakConnectionList{})
+}
@@ -14,6 +14,26 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdminEventsSpec) DeepCopyInto(out *AdminEventsSpec) {
+	*out = *in
+	if in.PollInterval != nil {
+		in, out := &in.PollInterval, &out.PollInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdminEventsSpec.
+func (in *AdminEventsSpec) DeepCopy() *AdminEventsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AdminEventsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AttributeSync) DeepCopyInto(out *AttributeSync) {
 	*out = *in
@@ -76,12 +96,57 @@ func (in *AttributeSyncList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AttributeSyncSpec) DeepCopyInto(out *AttributeSyncSpec) {
 	*out = *in
+	if in.ConnectionRef != nil {
+		in, out := &in.ConnectionRef, &out.ConnectionRef
+		*out = new(ConnectionReference)
+		**out = **in
+	}
 	if in.CaSecret != nil {
 		in, out := &in.CaSecret, &out.CaSecret
 		*out = new(v1.SecretReference)
 		**out = **in
 	}
+	if in.ClientCertSecret != nil {
+		in, out := &in.ClientCertSecret, &out.ClientCertSecret
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(HTTPSpec)
+		(*in).DeepCopyInto(*out)
+	}
 	out.CredentialsSecret = in.CredentialsSecret
+	if in.ReverseSync != nil {
+		in, out := &in.ReverseSync, &out.ReverseSync
+		*out = new(ReverseSyncSpec)
+		**out = **in
+	}
+	if in.ScheduleJitter != nil {
+		in, out := &in.ScheduleJitter, &out.ScheduleJitter
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.StartingDeadline != nil {
+		in, out := &in.StartingDeadline, &out.StartingDeadline
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.AdminEvents != nil {
+		in, out := &in.AdminEvents, &out.AdminEvents
+		*out = new(AdminEventsSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Incremental != nil {
+		in, out := &in.Incremental, &out.Incremental
+		*out = new(IncrementalSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Report != nil {
+		in, out := &in.Report, &out.Report
+		*out = new(ReportSpec)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AttributeSyncSpec.
@@ -104,6 +169,23 @@ func (in *AttributeSyncStatus) DeepCopyInto(out *AttributeSyncStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastAdminEventTime != nil {
+		in, out := &in.LastAdminEventTime, &out.LastAdminEventTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastFullSyncTime != nil {
+		in, out := &in.LastFullSyncTime, &out.LastFullSyncTime
+		*out = (*in).DeepCopy()
+	}
+	if in.PlannedChanges != nil {
+		in, out := &in.PlannedChanges, &out.PlannedChanges
+		*out = make([]PlannedChange, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AttributeSyncStatus.
@@ -115,3 +197,295 @@ func (in *AttributeSyncStatus) DeepCopy() *AttributeSyncStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterAttributeSync) DeepCopyInto(out *ClusterAttributeSync) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterAttributeSync.
+func (in *ClusterAttributeSync) DeepCopy() *ClusterAttributeSync {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterAttributeSync)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterAttributeSync) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterAttributeSyncList) DeepCopyInto(out *ClusterAttributeSyncList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterAttributeSync, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterAttributeSyncList.
+func (in *ClusterAttributeSyncList) DeepCopy() *ClusterAttributeSyncList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterAttributeSyncList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterAttributeSyncList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConnectionReference) DeepCopyInto(out *ConnectionReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConnectionReference.
+func (in *ConnectionReference) DeepCopy() *ConnectionReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ConnectionReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPSpec) DeepCopyInto(out *HTTPSpec) {
+	*out = *in
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Retries != nil {
+		in, out := &in.Retries, &out.Retries
+		*out = new(int)
+		**out = **in
+	}
+	if in.Headers != nil {
+		in, out := &in.Headers, &out.Headers
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPSpec.
+func (in *HTTPSpec) DeepCopy() *HTTPSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IncrementalSpec) DeepCopyInto(out *IncrementalSpec) {
+	*out = *in
+	if in.FullSyncInterval != nil {
+		in, out := &in.FullSyncInterval, &out.FullSyncInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IncrementalSpec.
+func (in *IncrementalSpec) DeepCopy() *IncrementalSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IncrementalSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeycloakConnection) DeepCopyInto(out *KeycloakConnection) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeycloakConnection.
+func (in *KeycloakConnection) DeepCopy() *KeycloakConnection {
+	if in == nil {
+		return nil
+	}
+	out := new(KeycloakConnection)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KeycloakConnection) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeycloakConnectionList) DeepCopyInto(out *KeycloakConnectionList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]KeycloakConnection, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeycloakConnectionList.
+func (in *KeycloakConnectionList) DeepCopy() *KeycloakConnectionList {
+	if in == nil {
+		return nil
+	}
+	out := new(KeycloakConnectionList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KeycloakConnectionList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeycloakConnectionSpec) DeepCopyInto(out *KeycloakConnectionSpec) {
+	*out = *in
+	if in.CaSecret != nil {
+		in, out := &in.CaSecret, &out.CaSecret
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+	if in.ClientCertSecret != nil {
+		in, out := &in.ClientCertSecret, &out.ClientCertSecret
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(HTTPSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	out.CredentialsSecret = in.CredentialsSecret
+	if in.CheckInterval != nil {
+		in, out := &in.CheckInterval, &out.CheckInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeycloakConnectionSpec.
+func (in *KeycloakConnectionSpec) DeepCopy() *KeycloakConnectionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KeycloakConnectionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeycloakConnectionStatus) DeepCopyInto(out *KeycloakConnectionStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastCheckTime != nil {
+		in, out := &in.LastCheckTime, &out.LastCheckTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeycloakConnectionStatus.
+func (in *KeycloakConnectionStatus) DeepCopy() *KeycloakConnectionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KeycloakConnectionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlannedChange) DeepCopyInto(out *PlannedChange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlannedChange.
+func (in *PlannedChange) DeepCopy() *PlannedChange {
+	if in == nil {
+		return nil
+	}
+	out := new(PlannedChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportSpec) DeepCopyInto(out *ReportSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportSpec.
+func (in *ReportSpec) DeepCopy() *ReportSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReverseSyncSpec) DeepCopyInto(out *ReverseSyncSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReverseSyncSpec.
+func (in *ReverseSyncSpec) DeepCopy() *ReverseSyncSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ReverseSyncSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/Nerzal/gocloak/v9"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/tools/clientcmd"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+	"github.com/appuio/keycloak-attribute-sync-controller/controllers"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/keycloak"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/sync"
+)
+
+// commands are the subcommands running a single synchronization instead of the manager
+var commands = map[string]func(ctx context.Context, o *cliOptions) error{
+	"sync":        runSync,
+	"diff":        runDiff,
+	"fetch-users": runFetchUsers,
+}
+
+type cliOptions struct {
+	manifest   string
+	kubeconfig string
+	namespace  string
+	output     string
+	workers    int
+
+	instance keycloakv1alpha1.AttributeSyncObject
+	client   client.Client
+	out      io.Writer
+	// keycloakClientBuilder defaults to keycloak.NewClient
+	keycloakClientBuilder func(baseUrl, loginRealm, username, password string, tlsConfig *tls.Config, transport keycloak.TransportOptions) keycloak.Client
+}
+
+// runCommand runs the given subcommand and returns the exit code.
+func runCommand(name string, args []string) int {
+	o := &cliOptions{out: os.Stdout, keycloakClientBuilder: keycloak.NewClient}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s -f <attributesync.yaml|clusterattributesync.yaml> [flags]\n", os.Args[0], name)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&o.manifest, "f", "", "Path to the AttributeSync or ClusterAttributeSync manifest.")
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig. Defaults to the KUBECONFIG environment variable or ~/.kube/config.")
+	fs.StringVar(&o.namespace, "n", "", "Namespace of the AttributeSync if not set in the manifest, or of the secrets and connection of a ClusterAttributeSync "+
+		"referenced without a namespace. Defaults to the namespace of the kubeconfig context.")
+	fs.StringVar(&o.output, "o", "table", "Output format, either table or json.")
+	fs.IntVar(&o.workers, "workers", 4, "Number of OpenShift users updated in parallel.")
+	opts := zap.Options{}
+	opts.BindFlags(fs)
+	fs.Parse(args)
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	if o.manifest == "" {
+		fs.Usage()
+		return 2
+	}
+	if o.output != "table" && o.output != "json" {
+		fmt.Fprintf(os.Stderr, "invalid output format %q\n", o.output)
+		return 2
+	}
+
+	if err := o.complete(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := commands[name](ctrl.SetupSignalHandler(), o); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// complete reads the manifest and sets up the Kubernetes client.
+func (o *cliOptions) complete() error {
+	data, err := os.ReadFile(o.manifest)
+	if err != nil {
+		return fmt.Errorf("error reading manifest: %w", err)
+	}
+	instance, err := decodeManifest(data)
+	if err != nil {
+		return err
+	}
+
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: o.kubeconfig, Precedence: clientcmd.NewDefaultClientConfigLoadingRules().Precedence},
+		&clientcmd.ConfigOverrides{},
+	)
+	if o.namespace == "" {
+		o.namespace, _, err = loader.Namespace()
+		if err != nil {
+			return fmt.Errorf("error loading kubeconfig: %w", err)
+		}
+	}
+	if namespaced, ok := instance.(*keycloakv1alpha1.AttributeSync); ok && namespaced.Namespace == "" {
+		namespaced.Namespace = o.namespace
+	}
+	cfg, err := loader.ClientConfig()
+	if err != nil {
+		return fmt.Errorf("error loading kubeconfig: %w", err)
+	}
+	o.client, err = client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return fmt.Errorf("error creating client: %w", err)
+	}
+	o.instance = instance
+	return nil
+}
+
+// decodeManifest decodes an AttributeSync or ClusterAttributeSync manifest.
+func decodeManifest(data []byte) (keycloakv1alpha1.AttributeSyncObject, error) {
+	obj, _, err := serializer.NewCodecFactory(scheme).UniversalDeserializer().Decode(data, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding manifest: %w", err)
+	}
+	instance, ok := obj.(keycloakv1alpha1.AttributeSyncObject)
+	if !ok {
+		return nil, fmt.Errorf("error decoding manifest: expected an AttributeSync or ClusterAttributeSync, got %s", obj.GetObjectKind().GroupVersionKind().Kind)
+	}
+	return instance, nil
+}
+
+// keycloakClient returns a Keycloak client using the connection details of the AttributeSync, the same way the controller does.
+// References of a ClusterAttributeSync without a namespace use the namespace of the command.
+func (o *cliOptions) keycloakClient(ctx context.Context) (keycloak.Client, error) {
+	r := &controllers.AttributeSyncReconciler{
+		Client:                   o.client,
+		Scheme:                   scheme,
+		KeycloakClientBuilder:    o.keycloakClientBuilder,
+		ClusterResourceNamespace: o.namespace,
+	}
+	return r.KeycloakClient(ctx, o.instance)
+}
+
+// userSyncer returns a UserSyncer recording all changes, either planned by a dry run or applied.
+func (o *cliOptions) userSyncer(ctx context.Context, dryRun bool) (*sync.UserSyncer, error) {
+	kc, err := o.keycloakClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sync.UserSyncer{
+		KeycloakClient: kc,
+		K8sClient:      o.client,
+		Workers:        o.workers,
+		Owner:          client.ObjectKeyFromObject(o.instance),
+		DryRun:         dryRun,
+		RecordChanges:  true,
+	}, nil
+}
+
+// sync runs a full synchronization including the reverse synchronization, if configured.
+func (o *cliOptions) sync(ctx context.Context, syncer *sync.UserSyncer) error {
+	spec := o.instance.GetSpec()
+	if err := syncer.Sync(ctx, spec.Realm, spec.Attribute, spec.TargetLabel, spec.TargetAnnotation); err != nil {
+		return err
+	}
+	if rs := spec.ReverseSync; rs != nil {
+		if err := syncer.ReverseSync(ctx, spec.Realm, rs.SourceLabel, rs.SourceAnnotation, rs.Attribute); err != nil {
+			return fmt.Errorf("error reverse syncing users: %w", err)
+		}
+	}
+	return nil
+}
+
+// runDiff prints the changes a synchronization would make.
+func runDiff(ctx context.Context, o *cliOptions) error {
+	syncer, err := o.userSyncer(ctx, true)
+	if err != nil {
+		return err
+	}
+	if err := o.sync(ctx, syncer); err != nil {
+		return err
+	}
+	return o.printChanges(syncer.PlannedChanges())
+}
+
+// runSync runs a single synchronization and prints the changes it applied.
+// The changes are printed even if the synchronization failed for some users.
+// If the manifest enables a dry run, nothing is applied and the planned changes are printed like diff does.
+func runSync(ctx context.Context, o *cliOptions) error {
+	if o.instance.GetSpec().DryRun {
+		ctrl.Log.Info("dryRun is set in the manifest, printing the planned changes without applying them")
+		return runDiff(ctx, o)
+	}
+	syncer, err := o.userSyncer(ctx, false)
+	if err != nil {
+		return err
+	}
+	syncErr := o.sync(ctx, syncer)
+	if err := o.printChanges(syncer.PlannedChanges()); err != nil {
+		return err
+	}
+	if syncErr != nil {
+		return syncErr
+	}
+	if o.output == "table" {
+		stats := syncer.Stats()
+		fmt.Fprintf(o.out, "\nSynced users: %d fetched, %d updated, %d skipped\n", stats.Fetched, stats.Updated, stats.Skipped)
+	}
+	return nil
+}
+
+// fetchedUser is a Keycloak user with the value of the synced attribute
+type fetchedUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Value    string `json:"value"`
+}
+
+// runFetchUsers prints all Keycloak users with the value of the synced attribute.
+func runFetchUsers(ctx context.Context, o *cliOptions) error {
+	kc, err := o.keycloakClient(ctx)
+	if err != nil {
+		return err
+	}
+	users, err := kc.GetUsers(ctx, o.instance.GetSpec().Realm, gocloak.GetUsersParams{
+		Max: gocloak.IntP(-1),
+	})
+	if err != nil {
+		return fmt.Errorf("error fetching users: %w", err)
+	}
+
+	fetched := make([]fetchedUser, 0, len(users))
+	for _, user := range users {
+		u := fetchedUser{ID: gocloak.PString(user.ID), Username: gocloak.PString(user.Username)}
+		if user.Attributes != nil {
+			if values := (*user.Attributes)[o.instance.GetSpec().Attribute]; len(values) > 0 {
+				u.Value = values[0]
+			}
+		}
+		fetched = append(fetched, u)
+	}
+
+	if o.output == "json" {
+		return o.printJSON(fetched)
+	}
+	w := tabwriter.NewWriter(o.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tVALUE")
+	for _, u := range fetched {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", u.ID, u.Username, u.Value)
+	}
+	return w.Flush()
+}
+
+func (o *cliOptions) printChanges(changes []sync.PlannedChange) error {
+	if o.output == "json" {
+		if changes == nil {
+			changes = []sync.PlannedChange{}
+		}
+		return o.printJSON(changes)
+	}
+	w := tabwriter.NewWriter(o.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tKIND\tKEY\tOLD VALUE\tNEW VALUE\tACTION")
+	for _, c := range changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.User, c.Kind, c.Key, c.OldValue, c.NewValue, c.Action)
+	}
+	return w.Flush()
+}
+
+func (o *cliOptions) printJSON(v interface{}) error {
+	enc := json.NewEncoder(o.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
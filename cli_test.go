@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v9"
+	userv1 "github.com/openshift/api/user/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/keycloak"
+)
+
+const (
+	testAttribute = "example.com/organization"
+	testLabel     = "example.com/keycloak-organization"
+)
+
+func newTestOptions(t *testing.T, instance keycloakv1alpha1.AttributeSyncObject, output string) (*cliOptions, *bytes.Buffer) {
+	kc := &keycloak.FakeClient{Users: []*gocloak.User{
+		keycloak.UserWithAttribute("alice", testAttribute, "IgniteCyber"),
+		keycloak.UserWithAttribute("bob", testAttribute, "Blockchain"),
+	}}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "sync"},
+			Data:       map[string][]byte{"username": []byte("user"), "password": []byte("pw")},
+		},
+		&userv1.User{ObjectMeta: metav1.ObjectMeta{Name: "alice"}},
+		&userv1.User{ObjectMeta: metav1.ObjectMeta{Name: "bob", Labels: map[string]string{testLabel: "Blockchain"}}},
+	).Build()
+
+	out := &bytes.Buffer{}
+	return &cliOptions{
+		namespace: "sync",
+		output:    output,
+		workers:   2,
+		instance:  instance,
+		client:    c,
+		out:       out,
+		keycloakClientBuilder: func(string, string, string, string, *tls.Config, keycloak.TransportOptions) keycloak.Client {
+			return kc
+		},
+	}, out
+}
+
+func TestCommands(t *testing.T) {
+	spec := keycloakv1alpha1.AttributeSyncSpec{
+		Realm:             "realm",
+		URL:               "https://keycloak.example.com",
+		Attribute:         testAttribute,
+		TargetLabel:       testLabel,
+		CredentialsSecret: corev1.SecretReference{Name: "credentials"},
+	}
+	instances := map[string]keycloakv1alpha1.AttributeSyncObject{
+		"AttributeSync":        &keycloakv1alpha1.AttributeSync{ObjectMeta: metav1.ObjectMeta{Name: "organization", Namespace: "sync"}, Spec: spec},
+		"ClusterAttributeSync": &keycloakv1alpha1.ClusterAttributeSync{ObjectMeta: metav1.ObjectMeta{Name: "organization"}, Spec: spec},
+	}
+
+	tests := []struct {
+		command string
+		output  string
+		want    string
+		// dryRun enables the dry run in the manifest
+		dryRun bool
+		// labeled is true if the command updates the OpenShift users
+		labeled bool
+	}{
+		{
+			command: "diff",
+			output:  "table",
+			want: "USER   KIND   KEY                                OLD VALUE  NEW VALUE    ACTION\n" +
+				"alice  Label  example.com/keycloak-organization             IgniteCyber  Add\n",
+		},
+		{
+			command: "diff",
+			output:  "json",
+			want: `[
+  {
+    "user": "alice",
+    "kind": "Label",
+    "key": "example.com/keycloak-organization",
+    "oldValue": "",
+    "newValue": "IgniteCyber",
+    "action": "Add"
+  }
+]
+`,
+		},
+		{
+			command: "sync",
+			output:  "table",
+			want: "USER   KIND   KEY                                OLD VALUE  NEW VALUE    ACTION\n" +
+				"alice  Label  example.com/keycloak-organization             IgniteCyber  Add\n" +
+				"\nSynced users: 2 fetched, 2 updated, 0 skipped\n",
+			labeled: true,
+		},
+		{
+			command: "sync",
+			output:  "json",
+			want: `[
+  {
+    "user": "alice",
+    "kind": "Label",
+    "key": "example.com/keycloak-organization",
+    "oldValue": "",
+    "newValue": "IgniteCyber",
+    "action": "Add"
+  }
+]
+`,
+			labeled: true,
+		},
+		{
+			command: "sync",
+			output:  "table",
+			dryRun:  true,
+			want: "USER   KIND   KEY                                OLD VALUE  NEW VALUE    ACTION\n" +
+				"alice  Label  example.com/keycloak-organization             IgniteCyber  Add\n",
+		},
+		{
+			command: "fetch-users",
+			output:  "table",
+			want: "ID     USERNAME  VALUE\n" +
+				"alice  alice     IgniteCyber\n" +
+				"bob    bob       Blockchain\n",
+		},
+		{
+			command: "fetch-users",
+			output:  "json",
+			want: `[
+  {
+    "id": "alice",
+    "username": "alice",
+    "value": "IgniteCyber"
+  },
+  {
+    "id": "bob",
+    "username": "bob",
+    "value": "Blockchain"
+  }
+]
+`,
+		},
+	}
+	for kind, instance := range instances {
+		for _, tc := range tests {
+			name := kind + "/" + tc.command + "/" + tc.output
+			if tc.dryRun {
+				name += "/dryRun"
+			}
+			t.Run(name, func(t *testing.T) {
+				instance := instance.DeepCopyObject().(keycloakv1alpha1.AttributeSyncObject)
+				instance.GetSpec().DryRun = tc.dryRun
+				o, out := newTestOptions(t, instance, tc.output)
+
+				require.NoError(t, commands[tc.command](context.Background(), o))
+				assert.Equal(t, tc.want, out.String())
+
+				user := &userv1.User{}
+				require.NoError(t, o.client.Get(context.Background(), client.ObjectKey{Name: "alice"}, user))
+				if tc.labeled {
+					assert.Equal(t, "IgniteCyber", user.Labels[testLabel])
+				} else {
+					assert.NotContains(t, user.Labels, testLabel)
+				}
+			})
+		}
+	}
+}
+
+// failingClient rejects updates of the given users
+type failingClient struct {
+	client.Client
+	failing map[string]bool
+}
+
+func (c *failingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if c.failing[obj.GetName()] {
+		return errors.New("update rejected")
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func testInstance() *keycloakv1alpha1.AttributeSync {
+	return &keycloakv1alpha1.AttributeSync{
+		ObjectMeta: metav1.ObjectMeta{Name: "organization", Namespace: "sync"},
+		Spec: keycloakv1alpha1.AttributeSyncSpec{
+			Realm:             "realm",
+			URL:               "https://keycloak.example.com",
+			Attribute:         testAttribute,
+			TargetLabel:       testLabel,
+			CredentialsSecret: corev1.SecretReference{Name: "credentials"},
+		},
+	}
+}
+
+func TestRunSync_UserErrors(t *testing.T) {
+	o, out := newTestOptions(t, testInstance(), "table")
+	o.client = &failingClient{Client: o.client, failing: map[string]bool{"bob": true}}
+
+	err := runSync(context.Background(), o)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "bob")
+	assert.Equal(t, "USER   KIND   KEY                                OLD VALUE  NEW VALUE    ACTION\n"+
+		"alice  Label  example.com/keycloak-organization             IgniteCyber  Add\n", out.String(),
+		"the changes applied before the failure are printed without the summary")
+}
+
+func TestCommands_MissingCredentials(t *testing.T) {
+	for _, command := range []string{"diff", "sync", "fetch-users"} {
+		t.Run(command, func(t *testing.T) {
+			instance := testInstance()
+			instance.Spec.CredentialsSecret.Name = "missing"
+			o, out := newTestOptions(t, instance, "table")
+
+			assert.Error(t, commands[command](context.Background(), o))
+			assert.Empty(t, out.String())
+		})
+	}
+}
+
+func TestDecodeManifest(t *testing.T) {
+	tests := map[string]struct {
+		manifest string
+		want     keycloakv1alpha1.AttributeSyncObject
+		wantErr  bool
+	}{
+		"AttributeSync": {
+			manifest: `
+apiVersion: keycloak.appuio.io/v1alpha1
+kind: AttributeSync
+metadata:
+  name: organization
+  namespace: sync
+spec:
+  realm: realm
+  attribute: example.com/organization
+`,
+			want: &keycloakv1alpha1.AttributeSync{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "keycloak.appuio.io/v1alpha1", Kind: "AttributeSync"},
+				ObjectMeta: metav1.ObjectMeta{Name: "organization", Namespace: "sync"},
+				Spec:       keycloakv1alpha1.AttributeSyncSpec{Realm: "realm", Attribute: testAttribute},
+			},
+		},
+		"ClusterAttributeSync": {
+			manifest: `
+apiVersion: keycloak.appuio.io/v1alpha1
+kind: ClusterAttributeSync
+metadata:
+  name: organization
+spec:
+  realm: realm
+  attribute: example.com/organization
+`,
+			want: &keycloakv1alpha1.ClusterAttributeSync{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "keycloak.appuio.io/v1alpha1", Kind: "ClusterAttributeSync"},
+				ObjectMeta: metav1.ObjectMeta{Name: "organization"},
+				Spec:       keycloakv1alpha1.AttributeSyncSpec{Realm: "realm", Attribute: testAttribute},
+			},
+		},
+		"other kind": {
+			manifest: `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+`,
+			wantErr: true,
+		},
+		"invalid": {
+			manifest: "kind: [",
+			wantErr:  true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			instance, err := decodeManifest([]byte(tc.manifest))
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, instance)
+		})
+	}
+}
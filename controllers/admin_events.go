@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/keycloak"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/sync"
+)
+
+// adminEventsPageSize is the number of admin events fetched per request
+const adminEventsPageSize = 100
+
+// syncAdminEvents syncs all users updated since the last processed admin event and records the newest processed event in the status.
+// Keycloak only pages admin events by offset, newest first, and filters them by day. Events stored while the pages are fetched
+// shift the offsets, so events may be returned twice, which is harmless as every user is synced once. Such events are newer than
+// all fetched events and are processed by the next poll. Events at the time of the last processed event are processed again,
+// as further events with the same time may have been stored after it was fetched.
+func (r *AttributeSyncReconciler) syncAdminEvents(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject, syncer *sync.UserSyncer) error {
+	spec := instance.GetSpec()
+	l := log.FromContext(ctx)
+
+	since := instance.GetStatus().LastSyncTime.Time
+	if instance.GetStatus().LastAdminEventTime != nil {
+		since = instance.GetStatus().LastAdminEventTime.Time
+	}
+	sinceMillis := since.UnixNano() / int64(time.Millisecond)
+
+	params := keycloak.GetAdminEventsParams{
+		OperationTypes: []string{"UPDATE"},
+		ResourceTypes:  []string{"USER"},
+		// Keycloak filters by day in its own time zone, the exact time is checked below.
+		DateFrom: since.Add(-24 * time.Hour).UTC().Format("2006-01-02"),
+		Max:      adminEventsPageSize,
+	}
+
+	newest := since
+	seen := map[string]bool{}
+	userIDs := []string{}
+	for {
+		events, err := syncer.KeycloakClient.GetAdminEvents(ctx, spec.Realm, params)
+		if err != nil {
+			return fmt.Errorf("error fetching admin events: %w", err)
+		}
+
+		done := len(events) < adminEventsPageSize
+		for _, event := range events {
+			if event.Time < sinceMillis {
+				// Keycloak returns the newest events first, everything after this was already processed.
+				done = true
+				break
+			}
+			if t := time.Unix(0, event.Time*int64(time.Millisecond)); t.After(newest) {
+				newest = t
+			}
+			id := event.UserID()
+			if id == "" || seen[id] {
+				continue
+			}
+			seen[id] = true
+			userIDs = append(userIDs, id)
+		}
+		if done {
+			break
+		}
+		params.First += len(events)
+	}
+
+	if len(userIDs) > 0 {
+		l.Info("Syncing users from admin events", "count", len(userIDs))
+		err := syncer.SyncByID(ctx, spec.Realm, userIDs, spec.Attribute, spec.TargetLabel, spec.TargetAnnotation)
+		if err != nil {
+			return err
+		}
+	}
+
+	instance.GetStatus().LastAdminEventTime = &metav1.Time{Time: newest}
+	return nil
+}
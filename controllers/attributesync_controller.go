@@ -5,115 +5,529 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"net/url"
 	"time"
 
 	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
-	"github.com/robfig/cron"
+	userv1 "github.com/openshift/api/user/v1"
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/keycloak"
 	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/sync"
 )
 
-// AttributeSyncReconciler reconciles a AttributeSync object
+// keycloakClientBuilder returns a Keycloak client for the given connection details
+type keycloakClientBuilder = func(baseUrl, loginRealm, username, password string, tlsConfig *tls.Config, transport keycloak.TransportOptions) keycloak.Client
+
+// AttributeSyncReconciler reconciles AttributeSync and ClusterAttributeSync objects
 type AttributeSyncReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	// APIReader, if not nil, reads OpenShift users bypassing the cache when an update is retried after a conflict
+	APIReader client.Reader
+	Scheme    *runtime.Scheme
+
+	KeycloakClientBuilder keycloakClientBuilder
+
+	Recorder record.EventRecorder
+	// DriftEvents enables emitting an event on OpenShift users whose managed labels or annotations were changed by hand
+	DriftEvents bool
+	// ReferencePolicy restricts the namespaces of referenced secrets and connections
+	ReferencePolicy ReferencePolicy
+	// ClusterResourceNamespace is the namespace of secrets, connections and ConfigMaps of ClusterAttributeSync objects
+	// referenced without a namespace
+	ClusterResourceNamespace string
+	// MaxConcurrentReconciles is the number of AttributeSyncs, ClusterAttributeSyncs and OpenShift users reconciled in parallel each
+	MaxConcurrentReconciles int
+	// UserUpdateWorkers is the number of OpenShift users updated in parallel by a synchronization
+	UserUpdateWorkers int
+	// UserUpdateLimiter, if not nil, limits the rate of OpenShift user updates of all synchronizations
+	UserUpdateLimiter *rate.Limiter
+	// Sharder, if not nil, restricts the reconciled AttributeSyncs and ClusterAttributeSyncs to the shards owned by this replica
+	Sharder *Sharder
 
-	KeycloakClientBuilder func(baseUrl, loginRealm, username, password string, tlsConfig *tls.Config) keycloak.Client
+	snapshots *snapshotCache
 }
 
 //+kubebuilder:rbac:groups=keycloak.appuio.io,resources=attributesyncs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=keycloak.appuio.io,resources=attributesyncs/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=keycloak.appuio.io,resources=attributesyncs/finalizers,verbs=update
+//+kubebuilder:rbac:groups=keycloak.appuio.io,resources=clusterattributesyncs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=keycloak.appuio.io,resources=clusterattributesyncs/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=keycloak.appuio.io,resources=clusterattributesyncs/finalizers,verbs=update
 
 //+kubebuilder:rbac:groups=user.openshift.io,resources=users,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
 func (r *AttributeSyncReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return r.reconcile(ctx, req, &keycloakv1alpha1.AttributeSync{})
+}
+
+// reconcileCluster reconciles a ClusterAttributeSync the same way as an AttributeSync.
+func (r *AttributeSyncReconciler) reconcileCluster(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return r.reconcile(ctx, req, &keycloakv1alpha1.ClusterAttributeSync{})
+}
+
+func (r *AttributeSyncReconciler) reconcile(ctx context.Context, req ctrl.Request, instance keycloakv1alpha1.AttributeSyncObject) (ctrl.Result, error) {
 	l := log.FromContext(ctx)
 	l.Info("Reconciling")
 
-	instance := &keycloakv1alpha1.AttributeSync{}
 	err := r.Client.Get(ctx, req.NamespacedName, instance)
 	if err != nil {
+		if apierrors.IsNotFound(err) {
+			r.snapshots.delete(req.NamespacedName)
+			deleteMetrics(req.NamespacedName)
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
-	if !instance.ObjectMeta.DeletionTimestamp.IsZero() {
-		// Object is in the process of beeing deleted.
+	if !r.Sharder.Owns(instance) {
+		// Reconciled by the replica owning the shard, which requeues it on its own and exports its metrics.
+		// The snapshot is dropped as well, it would be outdated once the shard comes back.
+		l.V(1).Info("Instance belongs to a shard owned by another replica")
+		r.snapshots.delete(req.NamespacedName)
+		deleteMetrics(req.NamespacedName)
 		return ctrl.Result{}, nil
 	}
+	if !instance.GetDeletionTimestamp().IsZero() {
+		// Object is in the process of beeing deleted.
+		r.snapshots.delete(req.NamespacedName)
+		deleteMetrics(req.NamespacedName)
+		return ctrl.Result{}, r.finalize(ctx, instance)
+	}
+	if err := r.ensureFinalizer(ctx, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	spec := instance.GetSpec()
+	if spec.Suspend {
+		l.Info("Synchronization suspended")
+		return ctrl.Result{}, r.setSuspended(ctx, instance)
+	}
+	removeStatusCondition(&instance.GetStatus().Conditions, keycloakv1alpha1.ConditionSuspended)
 
-	username, password, err := r.fetchCredentials(ctx, instance.GetCredentialsSecret())
+	sched, err := parseSchedule(instance)
 	if err != nil {
-		err := fmt.Errorf("failed fetching credentials: %w", err)
+		err := &scheduleError{err: err}
 		r.setError(ctx, instance, err)
 		return ctrl.Result{}, err
 	}
 
-	tlsConfig, err := keycloakTLSConfig(ctx, r.Client, instance.GetCaSecret())
-	if err != nil {
-		err := fmt.Errorf("failed setting up tls config: %w", err)
+	currentTime := time.Now()
+	// A dry run always plans the changes for all users
+	adminEvents := spec.AdminEvents != nil && !spec.DryRun
+	fullSync := true
+	if adminEvents || sched != nil {
+		fullSync = fullSyncDue(instance, sched, currentTime)
+	}
+	// Rotated credentials or certificates and a changed connection are used right away instead of at the next scheduled run
+	refsHash := r.referencesHash(ctx, instance)
+	if refsHash != instance.GetStatus().ReferencesHash {
+		if instance.GetStatus().ReferencesHash != "" {
+			l.Info("Referenced connection or secrets changed")
+		}
+		fullSync = true
+	}
+	syncNow, requested := syncNowRequested(instance)
+	if requested {
+		l.Info("Synchronization requested", "syncNow", syncNow)
+		fullSync = true
+	}
+	if !fullSync && !adminEvents {
+		// Reconciles triggered in between runs, for example by a restart, must not move the schedule
+		return ctrl.Result{RequeueAfter: sched.nextRun(instance.GetStatus().LastSyncTime.Time, currentTime).Sub(currentTime)}, nil
+	}
+
+	if err := r.checkReverseSyncLoops(ctx, instance); err != nil {
 		r.setError(ctx, instance, err)
 		return ctrl.Result{}, err
 	}
 
-	client := r.KeycloakClientBuilder(
-		instance.Spec.URL,
-		instance.GetLoginRealm(),
-		username, password,
-		tlsConfig,
-	)
-
-	syncer := sync.UserSyncer{KeycloakClient: client, K8sClient: r.Client}
-	err = syncer.Sync(ctx, instance.Spec.Realm, instance.Spec.Attribute, instance.Spec.TargetLabel, instance.Spec.TargetAnnotation)
+	client, err := r.KeycloakClient(ctx, instance)
 	if err != nil {
-		err := fmt.Errorf("error syncing users: %w", err)
 		r.setError(ctx, instance, err)
 		return ctrl.Result{}, err
 	}
 
-	r.setSuccess(ctx, instance)
+	syncer := sync.UserSyncer{
+		KeycloakClient: client,
+		K8sClient:      r.Client,
+		APIReader:      r.APIReader,
+		Workers:        r.UserUpdateWorkers,
+		UpdateLimiter:  r.UserUpdateLimiter,
+		Owner:          req.NamespacedName,
+		EventRecorder:  r.Recorder,
+		DryRun:         spec.DryRun,
+	}
 
-	if instance.Spec.Schedule != "" {
-		// TODO(bastjan): Should have a validating webhook. It's currently not really
-		//                possible to use kustomize in commodore so it would be quite
-		//                complicated to implement in the component.
-		sched, err := cron.ParseStandard(instance.Spec.Schedule)
+	incremental := spec.Incremental != nil && !spec.DryRun
+	if incremental {
+		syncer.Fingerprints, err = r.loadFingerprints(ctx, instance)
 		if err != nil {
-			l.Error(err, "Error parsing reconciling schedule")
+			err := fmt.Errorf("failed loading fingerprints: %w", err)
+			r.setError(ctx, instance, err)
 			return ctrl.Result{}, err
 		}
+		syncer.SkipUnchanged = !driftCorrectionDue(instance, currentTime)
+	}
 
-		currentTime := time.Now()
-		nextScheduledTime := sched.Next(currentTime)
-		return ctrl.Result{RequeueAfter: nextScheduledTime.Sub(currentTime)}, nil
+	if spec.Report != nil && fullSync {
+		syncer.Report = sync.NewReport(instance.GetReportMaxUsers(), maxReportSize)
 	}
 
-	return ctrl.Result{}, nil
+	err = r.sync(ctx, instance, &syncer, fullSync, currentTime)
+	if requested {
+		// Acknowledged even if the synchronization failed, the request is not retried beyond the usual retries
+		instance.GetStatus().LastHandledSyncNow = syncNow
+	}
+	syncDuration.WithLabelValues(req.Namespace, req.Name).Observe(time.Since(currentTime).Seconds())
+	if syncer.Report != nil {
+		// The report is informational, failing to save it doesn't fail the synchronization
+		if err := r.saveReport(ctx, instance, syncer.Report, currentTime, err); err != nil {
+			l.Error(err, "unable to save report")
+		}
+	}
+	if incremental {
+		// Fingerprints of successfully synced users are kept even if the synchronization failed.
+		if err := r.saveFingerprints(ctx, instance, syncer.Fingerprints); err != nil {
+			l.Error(err, "unable to save fingerprints")
+		}
+	}
+	if err != nil {
+		if keycloakUnavailable(err) {
+			// Retried explicitly instead of with the generic backoff of controller-runtime
+			l.Error(err, "Keycloak unavailable")
+			return ctrl.Result{RequeueAfter: r.setUnavailable(ctx, instance, err)}, nil
+		}
+		r.setError(ctx, instance, err)
+		return ctrl.Result{}, err
+	}
+	if fullSync {
+		instance.GetStatus().ReferencesHash = refsHash
+	}
+	if incremental && fullSync && !syncer.SkipUnchanged {
+		instance.GetStatus().LastFullSyncTime = &metav1.Time{Time: currentTime}
+	}
+	lastSuccessfulSync.WithLabelValues(req.Namespace, req.Name).SetToCurrentTime()
+	setPlannedChanges(instance, syncer.PlannedChanges())
+	if spec.DryRun {
+		r.recordEvent(instance, corev1.EventTypeNormal, "DryRunCompleted",
+			"Dry run planned %d changes", instance.GetStatus().PlannedChangesCount)
+	} else if stats := syncer.Stats(); fullSync || stats.Fetched > 0 {
+		r.recordEvent(instance, corev1.EventTypeNormal, "SyncCompleted",
+			"Synced users: %d fetched, %d updated, %d skipped", stats.Fetched, stats.Updated, stats.Skipped)
+	}
+
+	r.setSuccess(ctx, instance)
+
+	requeueAfter := time.Duration(0)
+	if adminEvents {
+		requeueAfter = instance.GetAdminEventsPollInterval()
+	}
+	if sched != nil {
+		nextScheduledTime := sched.nextRun(instance.GetStatus().LastSyncTime.Time, currentTime)
+		if untilNext := nextScheduledTime.Sub(currentTime); requeueAfter == 0 || untilNext < requeueAfter {
+			requeueAfter = untilNext
+		}
+	}
+	if requeueAfter < 0 {
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *AttributeSyncReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.snapshots = newSnapshotCache()
+
+	for _, obj := range []client.Object{&keycloakv1alpha1.AttributeSync{}, &keycloakv1alpha1.ClusterAttributeSync{}} {
+		err := mgr.GetFieldIndexer().IndexField(context.Background(), obj, secretRefIndex, r.indexSecretRefs)
+		if err != nil {
+			return err
+		}
+		err = mgr.GetFieldIndexer().IndexField(context.Background(), obj, connectionRefIndex, r.indexConnectionRef)
+		if err != nil {
+			return err
+		}
+	}
+
+	options := controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}
+	bldr := ctrl.NewControllerManagedBy(mgr).
+		For(&keycloakv1alpha1.AttributeSync{}, builder.WithPredicates(instanceChangedPredicate())).
+		WithOptions(options).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(requestsFor(r.Client, &keycloakv1alpha1.AttributeSyncList{}, secretRefIndex)),
+			builder.WithPredicates(secretDataChangedPredicate()),
+		).
+		Watches(
+			&source.Kind{Type: &keycloakv1alpha1.KeycloakConnection{}},
+			handler.EnqueueRequestsFromMapFunc(requestsFor(r.Client, &keycloakv1alpha1.AttributeSyncList{}, connectionRefIndex)),
+			builder.WithPredicates(connectionChangedPredicate()),
+		)
+	if r.Sharder != nil {
+		bldr = bldr.Watches(r.Sharder.Source(&keycloakv1alpha1.AttributeSyncList{}), &handler.EnqueueRequestForObject{})
+	}
+	if err := bldr.Complete(r); err != nil {
+		return err
+	}
+
+	bldr = ctrl.NewControllerManagedBy(mgr).
+		For(&keycloakv1alpha1.ClusterAttributeSync{}, builder.WithPredicates(instanceChangedPredicate())).
+		WithOptions(options).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(requestsFor(r.Client, &keycloakv1alpha1.ClusterAttributeSyncList{}, secretRefIndex)),
+			builder.WithPredicates(secretDataChangedPredicate()),
+		).
+		Watches(
+			&source.Kind{Type: &keycloakv1alpha1.KeycloakConnection{}},
+			handler.EnqueueRequestsFromMapFunc(requestsFor(r.Client, &keycloakv1alpha1.ClusterAttributeSyncList{}, connectionRefIndex)),
+			builder.WithPredicates(connectionChangedPredicate()),
+		)
+	if r.Sharder != nil {
+		bldr = bldr.Watches(r.Sharder.Source(&keycloakv1alpha1.ClusterAttributeSyncList{}), &handler.EnqueueRequestForObject{})
+	}
+	if err := bldr.Complete(reconcile.Func(r.reconcileCluster)); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&keycloakv1alpha1.AttributeSync{}).
-		Complete(r)
+		Named("user").
+		For(&userv1.User{}, builder.WithPredicates(r.userPredicate(time.Now()))).
+		WithOptions(options).
+		Complete(reconcile.Func(r.reconcileUser))
+}
+
+// instanceChangedPredicate ignores updates of AttributeSyncs changing neither the spec, the annotations, the shard label nor the deletion timestamp.
+// Status updates of the controller itself must not trigger another synchronization.
+func instanceChangedPredicate() predicate.Predicate {
+	return predicate.Or(
+		predicate.GenerationChangedPredicate{},
+		predicate.AnnotationChangedPredicate{},
+		shardLabelChangedPredicate(),
+		predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return e.ObjectOld.GetDeletionTimestamp().IsZero() != e.ObjectNew.GetDeletionTimestamp().IsZero()
+			},
+		},
+	)
 }
 
-func (r *AttributeSyncReconciler) fetchCredentials(ctx context.Context, secretRef corev1.SecretReference) (string, string, error) {
+// KeycloakClient returns a Keycloak client using the connection details of the given instance.
+// It is also used by the command line interface to run a synchronization outside of the manager.
+func (r *AttributeSyncReconciler) KeycloakClient(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject) (keycloak.Client, error) {
+	conn, err := r.connection(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	return keycloakClient(ctx, r.Client, r.KeycloakClientBuilder, r.ReferencePolicy, conn)
+}
+
+// connection returns the KeycloakConnection referenced by the instance, or the connection details set in its spec.
+func (r *AttributeSyncReconciler) connection(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject) (*keycloakv1alpha1.KeycloakConnection, error) {
+	ref := instance.GetSpec().ConnectionRef
+	if ref == nil {
+		return instance.GetSpec().InlineConnection(r.resourceNamespace(instance)), nil
+	}
+
+	key := r.connectionKey(instance)
+	if err := r.ReferencePolicy.check(r.resourceNamespace(instance), key.Namespace, "KeycloakConnection", key.Name); err != nil {
+		return nil, err
+	}
+	conn := &keycloakv1alpha1.KeycloakConnection{}
+	if err := r.Client.Get(ctx, key, conn); err != nil {
+		return nil, &connectionError{err: err}
+	}
+	return conn, nil
+}
+
+// connectionKey returns the key of the KeycloakConnection referenced by the instance.
+func (r *AttributeSyncReconciler) connectionKey(instance keycloakv1alpha1.AttributeSyncObject) types.NamespacedName {
+	ref := instance.GetSpec().ConnectionRef
+	ns := ref.Namespace
+	if ns == "" {
+		ns = r.resourceNamespace(instance)
+	}
+	return types.NamespacedName{Namespace: ns, Name: ref.Name}
+}
+
+// resourceNamespace returns the namespace of objects belonging to the instance, which is the cluster resource namespace for a ClusterAttributeSync.
+func (r *AttributeSyncReconciler) resourceNamespace(instance keycloakv1alpha1.AttributeSyncObject) string {
+	if ns := instance.GetNamespace(); ns != "" {
+		return ns
+	}
+	return r.ClusterResourceNamespace
+}
+
+// keycloakClient returns a Keycloak client using the given connection details.
+// The secrets are only read if the reference policy allows referencing them from the namespace of the connection.
+func keycloakClient(ctx context.Context, c client.Client, builder keycloakClientBuilder, policy ReferencePolicy, conn *keycloakv1alpha1.KeycloakConnection) (keycloak.Client, error) {
+	username, password, err := fetchCredentials(ctx, c, policy, conn.Namespace, conn.GetCredentialsSecret())
+	if err != nil {
+		return nil, &credentialsError{err: err}
+	}
+
+	for _, ref := range []*corev1.SecretReference{conn.GetCaSecret(), conn.GetClientCertSecret()} {
+		if ref == nil {
+			continue
+		}
+		if err := policy.check(conn.Namespace, ref.Namespace, "secret", ref.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	tlsConfig, err := keycloakTLSConfig(ctx, c, conn.GetCaSecret(), conn.GetClientCertSecret())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errTLSConfig, err)
+	}
+
+	transport, err := transportOptions(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return builder(
+		conn.Spec.URL,
+		conn.GetLoginRealm(),
+		username, password,
+		tlsConfig,
+		transport,
+	), nil
+}
+
+// transportOptions returns the HTTP client configuration of the given connection.
+func transportOptions(conn *keycloakv1alpha1.KeycloakConnection) (keycloak.TransportOptions, error) {
+	opts := keycloak.TransportOptions{Timeout: conn.GetHTTPTimeout(), RetryCount: conn.GetHTTPRetries()}
+	if conn.Spec.HTTP == nil {
+		return opts, nil
+	}
+	if proxy := conn.Spec.HTTP.ProxyURL; proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return opts, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		// url.Parse accepts almost anything, such as a host without a scheme, which would only fail on the first request
+		switch {
+		case u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5":
+			return opts, fmt.Errorf("invalid proxy url %q: scheme must be http, https or socks5", proxy)
+		case u.Host == "":
+			return opts, fmt.Errorf("invalid proxy url %q: missing host", proxy)
+		}
+		opts.ProxyURL = proxy
+	}
+	opts.Headers = conn.Spec.HTTP.Headers
+	return opts, nil
+}
+
+// sync syncs either all users or, if admin events are enabled and no full synchronization is due, the users updated since the last run.
+func (r *AttributeSyncReconciler) sync(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject, syncer *sync.UserSyncer, fullSync bool, now time.Time) error {
+	spec := instance.GetSpec()
+	key := client.ObjectKeyFromObject(instance)
+	if !fullSync {
+		syncer.ValueRecorder = r.snapshots.get(key, spec.TargetLabel, spec.TargetAnnotation)
+		err := r.syncAdminEvents(ctx, instance, syncer)
+		if err != nil {
+			return fmt.Errorf("error syncing users from admin events: %w", err)
+		}
+		return nil
+	}
+
+	if spec.DryRun {
+		// Nothing is written in a dry run, so there is nothing to restore either
+		r.snapshots.delete(key)
+	} else {
+		syncer.ValueRecorder = r.snapshots.reset(key, spec.TargetLabel, spec.TargetAnnotation)
+	}
+	r.recordEvent(instance, corev1.EventTypeNormal, "SyncStarted", "Syncing all users of realm %q", spec.Realm)
+	err := syncer.Sync(ctx, spec.Realm, spec.Attribute, spec.TargetLabel, spec.TargetAnnotation)
+	if err != nil {
+		return fmt.Errorf("error syncing users: %w", err)
+	}
+	if rs := spec.ReverseSync; rs != nil {
+		err := syncer.ReverseSync(ctx, spec.Realm, rs.SourceLabel, rs.SourceAnnotation, rs.Attribute)
+		if err != nil {
+			return fmt.Errorf("error reverse syncing users: %w", err)
+		}
+	}
+	instance.GetStatus().LastSyncTime = &metav1.Time{Time: now}
+	if spec.AdminEvents != nil {
+		// Events up to now are covered by the full synchronization
+		instance.GetStatus().LastAdminEventTime = &metav1.Time{Time: now}
+	}
+	return nil
+}
+
+// listInstances returns all AttributeSync and ClusterAttributeSync objects.
+func (r *AttributeSyncReconciler) listInstances(ctx context.Context) ([]keycloakv1alpha1.AttributeSyncObject, error) {
+	namespaced := &keycloakv1alpha1.AttributeSyncList{}
+	if err := r.Client.List(ctx, namespaced); err != nil {
+		return nil, err
+	}
+	cluster := &keycloakv1alpha1.ClusterAttributeSyncList{}
+	if err := r.Client.List(ctx, cluster); err != nil {
+		return nil, err
+	}
+
+	instances := make([]keycloakv1alpha1.AttributeSyncObject, 0, len(namespaced.Items)+len(cluster.Items))
+	for i := range namespaced.Items {
+		instances = append(instances, &namespaced.Items[i])
+	}
+	for i := range cluster.Items {
+		instances = append(instances, &cluster.Items[i])
+	}
+	return instances, nil
+}
+
+// describe returns the kind and name of the instance for messages, such as `AttributeSync namespace/name` or `ClusterAttributeSync name`.
+func describe(instance keycloakv1alpha1.AttributeSyncObject) string {
+	return describeKey(client.ObjectKeyFromObject(instance))
+}
+
+// describeKey returns the kind and name of the AttributeSync or ClusterAttributeSync with the given key.
+func describeKey(key types.NamespacedName) string {
+	if key.Namespace == "" {
+		return "ClusterAttributeSync " + key.Name
+	}
+	return "AttributeSync " + key.Namespace + "/" + key.Name
+}
+
+// recordEvent emits an event if an event recorder is configured.
+func (r *AttributeSyncReconciler) recordEvent(obj runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder != nil {
+		r.Recorder.Eventf(obj, eventtype, reason, messageFmt, args...)
+	}
+}
+
+// fetchCredentials reads the username and password from the secret, if the reference policy allows referencing it from namespace `from`.
+func fetchCredentials(ctx context.Context, c client.Client, policy ReferencePolicy, from string, secretRef corev1.SecretReference) (string, string, error) {
+	if err := policy.check(from, secretRef.Namespace, "secret", secretRef.Name); err != nil {
+		return "", "", err
+	}
+
 	fmtErr := func(field string) error {
 		return fmt.Errorf("missing field `%s` in secret `%s/%s`", field, secretRef.Name, secretRef.Namespace)
 	}
 
 	secret := &corev1.Secret{}
-	err := r.Client.Get(ctx, types.NamespacedName{Name: secretRef.Name, Namespace: secretRef.Namespace}, secret)
+	err := c.Get(ctx, types.NamespacedName{Name: secretRef.Name, Namespace: secretRef.Namespace}, secret)
 	if err != nil {
 		return "", "", err
 	}
@@ -131,29 +545,72 @@ func (r *AttributeSyncReconciler) fetchCredentials(ctx context.Context, secretRe
 	return string(username), string(password), nil
 }
 
-func keycloakTLSConfig(ctx context.Context, client client.Client, caSecretRef *corev1.SecretReference) (*tls.Config, error) {
+// keycloakTLSConfig returns the TLS config to connect to Keycloak with the CA and client certificate from the given secrets.
+// The secrets are read on every call, so rotated certificates are picked up by the next synchronization.
+func keycloakTLSConfig(ctx context.Context, client client.Client, caSecretRef, clientCertSecretRef *corev1.SecretReference) (*tls.Config, error) {
 	const caSecretKey = "ca.crt"
 	conf := &tls.Config{}
 
-	if caSecretRef == nil {
-		return conf, nil
-	}
+	if caSecretRef != nil {
+		caSecret := &corev1.Secret{}
+		err := client.Get(ctx, types.NamespacedName{Namespace: caSecretRef.Namespace, Name: caSecretRef.Name}, caSecret)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching CA secret: %w", err)
+		}
 
-	caSecret := &corev1.Secret{}
-	err := client.Get(ctx, types.NamespacedName{Namespace: caSecretRef.Namespace, Name: caSecretRef.Name}, caSecret)
-	if err != nil {
-		return nil, fmt.Errorf("error fetching CA secret: %w", err)
-	}
+		ca, found := caSecret.Data[caSecretKey]
+		if !found {
+			return nil, fmt.Errorf("found no certificate in '%s/%s' with key '%s'", caSecretRef.Namespace, caSecretRef.Name, caSecretKey)
+		}
+
+		if conf.RootCAs == nil {
+			conf.RootCAs = x509.NewCertPool()
+		}
+		conf.RootCAs.AppendCertsFromPEM(ca)
 
-	ca, found := caSecret.Data[caSecretKey]
-	if !found {
-		return nil, fmt.Errorf("found no certificate in '%s/%s' with key '%s'", caSecretRef.Namespace, caSecretRef.Name, caSecretKey)
+		if clientCertSecretRef == nil {
+			cert, err := clientCertificate(caSecret, false)
+			if err != nil {
+				return nil, err
+			}
+			if cert != nil {
+				conf.Certificates = []tls.Certificate{*cert}
+			}
+		}
 	}
 
-	if conf.RootCAs == nil {
-		conf.RootCAs = x509.NewCertPool()
+	if clientCertSecretRef != nil {
+		certSecret := &corev1.Secret{}
+		err := client.Get(ctx, types.NamespacedName{Namespace: clientCertSecretRef.Namespace, Name: clientCertSecretRef.Name}, certSecret)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching client certificate secret: %w", err)
+		}
+
+		cert, err := clientCertificate(certSecret, true)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{*cert}
 	}
-	conf.RootCAs.AppendCertsFromPEM(ca)
 
 	return conf, nil
 }
+
+// clientCertificate loads the client certificate from the `tls.crt` and `tls.key` keys of the secret.
+// If required is false, nil is returned if the secret contains neither key.
+func clientCertificate(secret *corev1.Secret, required bool) (*tls.Certificate, error) {
+	certPEM, hasCert := secret.Data[corev1.TLSCertKey]
+	keyPEM, hasKey := secret.Data[corev1.TLSPrivateKeyKey]
+	if !hasCert && !hasKey && !required {
+		return nil, nil
+	}
+	if !hasCert || !hasKey {
+		return nil, fmt.Errorf("found no client certificate in '%s/%s' with keys '%s' and '%s'", secret.Namespace, secret.Name, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate in '%s/%s': %w", secret.Namespace, secret.Name, err)
+	}
+	return &cert, nil
+}
@@ -2,44 +2,239 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/keycloak"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/sync"
 	"github.com/redhat-cop/operator-utils/pkg/util/apis"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-func (r *AttributeSyncReconciler) setSuccess(ctx context.Context, instance *keycloakv1alpha1.AttributeSync) {
+// unavailableRequeueInterval is the time until a synchronization is retried after Keycloak was unavailable
+const unavailableRequeueInterval = 30 * time.Second
+
+// setSuccess marks the synchronization as successful. The ReconcileSuccess and ReconcileError conditions are kept
+// for compatibility, only one of them is set at a time.
+func (r *AttributeSyncReconciler) setSuccess(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject) {
 	l := log.FromContext(ctx)
 
-	condition := metav1.Condition{
+	removeStatusCondition(&instance.GetStatus().Conditions, apis.ReconcileError)
+	meta.SetStatusCondition(&instance.GetStatus().Conditions, metav1.Condition{
 		Type:               apis.ReconcileSuccess,
-		LastTransitionTime: metav1.Now(),
 		ObservedGeneration: instance.GetGeneration(),
 		Reason:             apis.ReconcileSuccessReason,
 		Status:             metav1.ConditionTrue,
-	}
-	instance.SetConditions(apis.AddOrReplaceCondition(condition, instance.GetConditions()))
-	err := r.Client.Status().Update(ctx, instance)
+	})
+	setStatusConditions(instance, nil)
+	err := r.updateStatus(ctx, instance)
 	if err != nil {
 		l.Error(err, "unable to update status")
 	}
 }
 
-func (r *AttributeSyncReconciler) setError(ctx context.Context, instance *keycloakv1alpha1.AttributeSync, reason error) {
+// setError marks the synchronization as failed and removes the ReconcileSuccess condition of an earlier synchronization.
+func (r *AttributeSyncReconciler) setError(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject, reason error) {
 	l := log.FromContext(ctx)
 
-	condition := metav1.Condition{
+	r.recordEvent(instance, corev1.EventTypeWarning, failureEventReason(reason), reason.Error())
+	removeStatusCondition(&instance.GetStatus().Conditions, apis.ReconcileSuccess)
+	meta.SetStatusCondition(&instance.GetStatus().Conditions, metav1.Condition{
 		Type:               apis.ReconcileError,
-		LastTransitionTime: metav1.Now(),
 		ObservedGeneration: instance.GetGeneration(),
 		Message:            reason.Error(),
 		Reason:             apis.ReconcileErrorReason,
 		Status:             metav1.ConditionTrue,
+	})
+	setStatusConditions(instance, reason)
+	err := r.updateStatus(ctx, instance)
+	if err != nil {
+		l.Error(err, "unable to update status")
+	}
+}
+
+// setUnavailable records that Keycloak could not be reached and returns the time until the synchronization should be retried.
+// Unlike setError, the ReconcileError condition is left untouched as the configuration is not at fault.
+func (r *AttributeSyncReconciler) setUnavailable(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject, reason error) time.Duration {
+	l := log.FromContext(ctx)
+
+	retryAfter := unavailableRequeueInterval
+	var unavailable *keycloak.UnavailableError
+	if errors.As(reason, &unavailable) && unavailable.RetryAfter > 0 {
+		retryAfter = unavailable.RetryAfter
 	}
-	instance.SetConditions(apis.AddOrReplaceCondition(condition, instance.GetConditions()))
-	err := r.Client.Status().Update(ctx, instance)
+
+	r.recordEvent(instance, corev1.EventTypeWarning, "KeycloakUnavailable", "%s, retrying in %s", reason.Error(), retryAfter.Round(time.Second))
+	setStatusConditions(instance, reason)
+	err := r.updateStatus(ctx, instance)
 	if err != nil {
 		l.Error(err, "unable to update status")
 	}
+	return retryAfter
+}
+
+// updateStatus writes the status of the instance unless it equals the status in the cache.
+// Conditions only change their transition time if their status changes, so repeating the last result doesn't need a write.
+func (r *AttributeSyncReconciler) updateStatus(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject) error {
+	cached := instance.DeepCopyObject().(keycloakv1alpha1.AttributeSyncObject)
+	// Cleared, as decoding doesn't reset fields missing in the stored object
+	*cached.GetStatus() = keycloakv1alpha1.AttributeSyncStatus{}
+	err := r.Client.Get(ctx, client.ObjectKeyFromObject(instance), cached)
+	if err == nil && equality.Semantic.DeepEqual(cached.GetStatus(), instance.GetStatus()) {
+		return nil
+	}
+	return r.Client.Status().Update(ctx, instance)
+}
+
+// setStatusConditions sets the CredentialsValid, KeycloakReachable, Synced, Degraded and Ready conditions from the result of a reconciliation.
+// A nil error marks all conditions as healthy. Otherwise the condition describing the failure is set, and Synced is false as nothing was synced.
+// The conditions of stages that weren't reached because of the failure are set to unknown, so they neither keep reporting
+// an earlier failure nor claim a success that wasn't checked.
+func setStatusConditions(instance keycloakv1alpha1.AttributeSyncObject, reason error) {
+	set := func(condType string, status metav1.ConditionStatus, condReason, message string) {
+		meta.SetStatusCondition(&instance.GetStatus().Conditions, metav1.Condition{
+			Type:               condType,
+			Status:             status,
+			ObservedGeneration: instance.GetGeneration(),
+			Reason:             condReason,
+			Message:            message,
+		})
+	}
+
+	if reason == nil {
+		set(keycloakv1alpha1.ConditionCredentialsValid, metav1.ConditionTrue, keycloakv1alpha1.ReasonCredentialsValid, "")
+		set(keycloakv1alpha1.ConditionKeycloakReachable, metav1.ConditionTrue, keycloakv1alpha1.ReasonKeycloakReachable, "")
+		set(keycloakv1alpha1.ConditionSynced, metav1.ConditionTrue, keycloakv1alpha1.ReasonSynced, "")
+		set(keycloakv1alpha1.ConditionDegraded, metav1.ConditionFalse, keycloakv1alpha1.ReasonAllUsersSynced, "")
+	} else {
+		condType, condReason := failureCondition(reason)
+		switch {
+		case condType == keycloakv1alpha1.ConditionDegraded:
+			// Keycloak was reachable with valid credentials, only some OpenShift users failed
+			set(keycloakv1alpha1.ConditionCredentialsValid, metav1.ConditionTrue, keycloakv1alpha1.ReasonCredentialsValid, "")
+			set(keycloakv1alpha1.ConditionKeycloakReachable, metav1.ConditionTrue, keycloakv1alpha1.ReasonKeycloakReachable, "")
+			set(keycloakv1alpha1.ConditionDegraded, metav1.ConditionTrue, condReason, reason.Error())
+		case condType == keycloakv1alpha1.ConditionCredentialsValid || condType == keycloakv1alpha1.ConditionKeycloakReachable:
+			setUncheckedConditions(set, condType)
+			set(condType, metav1.ConditionFalse, condReason, reason.Error())
+		case condReason == keycloakv1alpha1.ReasonReferenceNotAllowed || condReason == keycloakv1alpha1.ReasonConnectionNotFound:
+			// The connection details are resolved before the credentials are read
+			setUncheckedConditions(set, "")
+		}
+		set(keycloakv1alpha1.ConditionSynced, metav1.ConditionFalse, condReason, reason.Error())
+	}
+
+	setReadyCondition(instance)
+}
+
+// setUncheckedConditions sets the CredentialsValid and KeycloakReachable conditions, except the failed one, to unknown.
+// Keycloak isn't contacted if the credentials can't be read, and the credentials are only checked by authenticating to Keycloak.
+func setUncheckedConditions(set func(condType string, status metav1.ConditionStatus, condReason, message string), failed string) {
+	for _, condType := range []string{keycloakv1alpha1.ConditionCredentialsValid, keycloakv1alpha1.ConditionKeycloakReachable} {
+		if condType != failed {
+			set(condType, metav1.ConditionUnknown, keycloakv1alpha1.ReasonNotChecked, "")
+		}
+	}
+}
+
+// setReadyCondition summarizes the other conditions. The instance is ready if the credentials are valid, Keycloak is reachable,
+// the last synchronization succeeded and no user failed. Otherwise the reason and message of the first failed condition are used.
+func setReadyCondition(instance keycloakv1alpha1.AttributeSyncObject) {
+	ready := metav1.Condition{
+		Type:               keycloakv1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: instance.GetGeneration(),
+		Reason:             keycloakv1alpha1.ReasonReady,
+	}
+	for _, condType := range []string{keycloakv1alpha1.ConditionCredentialsValid, keycloakv1alpha1.ConditionKeycloakReachable, keycloakv1alpha1.ConditionSynced} {
+		if cond := meta.FindStatusCondition(instance.GetStatus().Conditions, condType); cond != nil && cond.Status == metav1.ConditionFalse {
+			ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, cond.Reason, cond.Message
+			break
+		}
+	}
+	if cond := meta.FindStatusCondition(instance.GetStatus().Conditions, keycloakv1alpha1.ConditionDegraded); ready.Status == metav1.ConditionTrue && cond != nil && cond.Status == metav1.ConditionTrue {
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, cond.Reason, cond.Message
+	}
+	meta.SetStatusCondition(&instance.GetStatus().Conditions, ready)
+}
+
+// failureCondition returns the type and reason of the condition describing the given reconcile error.
+func failureCondition(err error) (string, string) {
+	var (
+		userErrs    sync.UserErrors
+		credsErr    *credentialsError
+		schedErr    *scheduleError
+		reverseErr  *reverseSyncError
+		connErr     *connectionError
+		refErr      *referenceError
+		loginErr    *keycloak.LoginError
+		secretError = func(err error) string {
+			if apierrors.IsNotFound(err) {
+				return keycloakv1alpha1.ReasonSecretNotFound
+			}
+			return keycloakv1alpha1.ReasonSecretInvalid
+		}
+	)
+	switch {
+	case errors.As(err, &userErrs):
+		return keycloakv1alpha1.ConditionDegraded, keycloakv1alpha1.ReasonUserUpdateFailed
+	case errors.As(err, &refErr):
+		return keycloakv1alpha1.ConditionSynced, keycloakv1alpha1.ReasonReferenceNotAllowed
+	case errors.As(err, &connErr):
+		if apierrors.IsNotFound(connErr.err) {
+			return keycloakv1alpha1.ConditionSynced, keycloakv1alpha1.ReasonConnectionNotFound
+		}
+		return keycloakv1alpha1.ConditionSynced, keycloakv1alpha1.ReasonSyncFailed
+	case errors.As(err, &credsErr):
+		return keycloakv1alpha1.ConditionCredentialsValid, secretError(credsErr.err)
+	case errors.Is(err, errTLSConfig):
+		return keycloakv1alpha1.ConditionKeycloakReachable, keycloakv1alpha1.ReasonTLSConfigInvalid
+	case keycloak.IsTLSError(err):
+		return keycloakv1alpha1.ConditionKeycloakReachable, keycloakv1alpha1.ReasonTLSFailed
+	case keycloak.IsTransient(err):
+		return keycloakv1alpha1.ConditionKeycloakReachable, keycloakv1alpha1.ReasonKeycloakUnavailable
+	case errors.As(err, &loginErr):
+		return keycloakv1alpha1.ConditionCredentialsValid, keycloakv1alpha1.ReasonAuthenticationFailed
+	case errors.As(err, &schedErr):
+		return keycloakv1alpha1.ConditionSynced, keycloakv1alpha1.ReasonInvalidSchedule
+	case errors.As(err, &reverseErr):
+		return keycloakv1alpha1.ConditionSynced, keycloakv1alpha1.ReasonReverseSyncConflict
+	default:
+		return keycloakv1alpha1.ConditionSynced, keycloakv1alpha1.ReasonSyncFailed
+	}
+}
+
+// keycloakUnavailable returns true if the error was caused by Keycloak being unreachable, as opposed to a configuration or OpenShift error.
+func keycloakUnavailable(err error) bool {
+	condType, reason := failureCondition(err)
+	return condType == keycloakv1alpha1.ConditionKeycloakReachable && reason == keycloakv1alpha1.ReasonKeycloakUnavailable
+}
+
+// failureEventReason returns the reason of the event emitted for the given reconcile error.
+func failureEventReason(err error) string {
+	var loginErr *keycloak.LoginError
+	switch {
+	case errors.Is(err, errTLSConfig) || keycloak.IsTLSError(err):
+		return "TLSFailed"
+	case errors.As(err, &loginErr):
+		return "AuthenticationFailed"
+	default:
+		return "SyncFailed"
+	}
+}
+
+// removeStatusCondition removes the condition of the given type.
+// Unlike meta.RemoveStatusCondition of apimachinery v0.20, it doesn't panic if there are no conditions.
+func removeStatusCondition(conditions *[]metav1.Condition, condType string) {
+	if len(*conditions) == 0 {
+		return
+	}
+	meta.RemoveStatusCondition(conditions, condType)
 }
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/Nerzal/gocloak/v9"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+)
+
+var _ = Describe("AttributeSync status conditions", func() {
+	notChecked := func(conditions []metav1.Condition, condType string) bool {
+		cond := meta.FindStatusCondition(conditions, condType)
+		return cond != nil && cond.Status == metav1.ConditionUnknown && cond.Reason == keycloakv1alpha1.ReasonNotChecked
+	}
+
+	It("It should report the current failure after the credentials were fixed", func() {
+		instance := &keycloakv1alpha1.AttributeSync{ObjectMeta: metav1.ObjectMeta{Name: "sync-organization", Namespace: "default"}}
+		conditions := &instance.Status.Conditions
+
+		By("By failing to fetch the credentials")
+		setStatusConditions(instance, &credentialsError{err: apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, "sync-organization")})
+		Expect(meta.FindStatusCondition(*conditions, keycloakv1alpha1.ConditionReady).Reason).Should(Equal(keycloakv1alpha1.ReasonSecretNotFound))
+		Expect(notChecked(*conditions, keycloakv1alpha1.ConditionKeycloakReachable)).Should(BeTrue())
+
+		By("By failing to reach Keycloak")
+		setStatusConditions(instance, &gocloak.APIError{Code: 503, Message: "503 Service Unavailable"})
+		Expect(notChecked(*conditions, keycloakv1alpha1.ConditionCredentialsValid)).Should(BeTrue())
+		Expect(meta.IsStatusConditionFalse(*conditions, keycloakv1alpha1.ConditionKeycloakReachable)).Should(BeTrue())
+		Expect(meta.FindStatusCondition(*conditions, keycloakv1alpha1.ConditionReady).Reason).Should(Equal(keycloakv1alpha1.ReasonKeycloakUnavailable))
+	})
+
+	It("It should not report valid credentials if the TLS handshake failed", func() {
+		instance := &keycloakv1alpha1.AttributeSync{ObjectMeta: metav1.ObjectMeta{Name: "sync-organization", Namespace: "default"}}
+		conditions := &instance.Status.Conditions
+
+		By("By synchronizing successfully")
+		setStatusConditions(instance, nil)
+		Expect(meta.IsStatusConditionTrue(*conditions, keycloakv1alpha1.ConditionCredentialsValid)).Should(BeTrue())
+
+		By("By failing to verify the certificate of Keycloak")
+		setStatusConditions(instance, fmt.Errorf("login failed: %w", x509.UnknownAuthorityError{}))
+		Expect(notChecked(*conditions, keycloakv1alpha1.ConditionCredentialsValid)).Should(BeTrue())
+		Expect(meta.FindStatusCondition(*conditions, keycloakv1alpha1.ConditionKeycloakReachable).Reason).Should(Equal(keycloakv1alpha1.ReasonTLSFailed))
+
+		By("By failing to build the TLS configuration")
+		setStatusConditions(instance, fmt.Errorf("%w: no certificates found", errTLSConfig))
+		Expect(notChecked(*conditions, keycloakv1alpha1.ConditionCredentialsValid)).Should(BeTrue())
+		Expect(meta.FindStatusCondition(*conditions, keycloakv1alpha1.ConditionKeycloakReachable).Reason).Should(Equal(keycloakv1alpha1.ReasonTLSConfigInvalid))
+		Expect(meta.FindStatusCondition(*conditions, keycloakv1alpha1.ConditionReady).Reason).Should(Equal(keycloakv1alpha1.ReasonTLSConfigInvalid))
+	})
+
+	It("It should not report valid credentials for an unreachable connection", func() {
+		conn := &keycloakv1alpha1.KeycloakConnection{ObjectMeta: metav1.ObjectMeta{Name: "keycloak", Namespace: "default"}}
+		conditions := &conn.Status.Conditions
+
+		By("By checking the connection successfully")
+		setConnectionConditions(conn, nil)
+		Expect(meta.IsStatusConditionTrue(*conditions, keycloakv1alpha1.ConditionCredentialsValid)).Should(BeTrue())
+
+		By("By failing to reach Keycloak")
+		setConnectionConditions(conn, &gocloak.APIError{Code: 503, Message: "503 Service Unavailable"})
+		Expect(notChecked(*conditions, keycloakv1alpha1.ConditionCredentialsValid)).Should(BeTrue())
+		Expect(meta.IsStatusConditionFalse(*conditions, keycloakv1alpha1.ConditionKeycloakReachable)).Should(BeTrue())
+
+		By("By failing to fetch the credentials")
+		setConnectionConditions(conn, &credentialsError{err: apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, "keycloak")})
+		Expect(meta.IsStatusConditionFalse(*conditions, keycloakv1alpha1.ConditionCredentialsValid)).Should(BeTrue())
+		Expect(notChecked(*conditions, keycloakv1alpha1.ConditionKeycloakReachable)).Should(BeTrue())
+	})
+})
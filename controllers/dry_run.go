@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/sync"
+)
+
+// maxPlannedChanges limits the number of planned changes stored in the status to keep the object small
+const maxPlannedChanges = 100
+
+// setPlannedChanges stores the changes planned by a dry run in the status. The changes are cleared if the run was no dry run.
+func setPlannedChanges(instance keycloakv1alpha1.AttributeSyncObject, changes []sync.PlannedChange) {
+	instance.GetStatus().PlannedChangesCount = len(changes)
+	if len(changes) > maxPlannedChanges {
+		changes = changes[:maxPlannedChanges]
+	}
+
+	instance.GetStatus().PlannedChanges = nil
+	for _, c := range changes {
+		instance.GetStatus().PlannedChanges = append(instance.GetStatus().PlannedChanges, keycloakv1alpha1.PlannedChange{
+			User:     c.User,
+			Kind:     c.Kind,
+			Key:      c.Key,
+			OldValue: c.OldValue,
+			NewValue: c.NewValue,
+			Action:   c.Action,
+		})
+	}
+}
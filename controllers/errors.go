@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"errors"
+)
+
+var errTLSConfig = errors.New("failed setting up tls config")
+
+// credentialsError is returned if the credentials secret is missing or incomplete
+type credentialsError struct {
+	err error
+}
+
+func (e *credentialsError) Error() string {
+	return "failed fetching credentials: " + e.err.Error()
+}
+
+func (e *credentialsError) Unwrap() error {
+	return e.err
+}
+
+// connectionError is returned if the KeycloakConnection referenced by an AttributeSync can't be fetched
+type connectionError struct {
+	err error
+}
+
+func (e *connectionError) Error() string {
+	return "failed fetching connection: " + e.err.Error()
+}
+
+func (e *connectionError) Unwrap() error {
+	return e.err
+}
+
+// referenceError is returned if the reference policy forbids referencing a secret or connection
+type referenceError struct {
+	msg string
+}
+
+func (e *referenceError) Error() string {
+	return "reference not allowed: " + e.msg
+}
+
+// scheduleError is returned if the schedule of an AttributeSync can't be parsed
+type scheduleError struct {
+	err error
+}
+
+func (e *scheduleError) Error() string {
+	return "invalid schedule: " + e.err.Error()
+}
+
+func (e *scheduleError) Unwrap() error {
+	return e.err
+}
+
+// reverseSyncError is returned if the reverse synchronization of an AttributeSync would conflict with a synchronization to the cluster
+type reverseSyncError struct {
+	msg string
+}
+
+func (e *reverseSyncError) Error() string {
+	return "invalid reverse synchronization: " + e.msg
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/sync"
+)
+
+// cleanupFinalizer is set on objects with the deletion policy Remove, so the synced labels and annotations can be removed before they are deleted
+const cleanupFinalizer = "attributesync.keycloak.appuio.io/cleanup"
+
+// ensureFinalizer adds the cleanup finalizer if the deletion policy of the instance is Remove, and removes it otherwise.
+func (r *AttributeSyncReconciler) ensureFinalizer(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject) error {
+	want := instance.GetSpec().DeletionPolicy == keycloakv1alpha1.DeletionPolicyRemove
+	if want == controllerutil.ContainsFinalizer(instance, cleanupFinalizer) {
+		return nil
+	}
+	if want {
+		controllerutil.AddFinalizer(instance, cleanupFinalizer)
+	} else {
+		controllerutil.RemoveFinalizer(instance, cleanupFinalizer)
+	}
+	return r.Client.Update(ctx, instance)
+}
+
+// finalize removes the synced labels and annotations from all OpenShift users and then the cleanup finalizer of the deleted instance.
+// Labels and annotations also targeted by another instance are kept, as are the sync time annotation shared by all instances
+// until the last one is deleted.
+func (r *AttributeSyncReconciler) finalize(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject) error {
+	if !controllerutil.ContainsFinalizer(instance, cleanupFinalizer) {
+		return nil
+	}
+	spec := instance.GetSpec()
+
+	// A dry run never wrote anything to the users
+	if spec.DeletionPolicy == keycloakv1alpha1.DeletionPolicyRemove && !spec.DryRun {
+		instances, err := r.listInstances(ctx)
+		if err != nil {
+			return err
+		}
+		lastInstance := true
+		targetLabel, targetAnnotation := spec.TargetLabel, spec.TargetAnnotation
+		for _, other := range instances {
+			if describe(other) == describe(instance) || !other.GetDeletionTimestamp().IsZero() {
+				continue
+			}
+			lastInstance = false
+			if other.GetSpec().TargetLabel == targetLabel {
+				targetLabel = ""
+			}
+			if other.GetSpec().TargetAnnotation == targetAnnotation {
+				targetAnnotation = ""
+			}
+		}
+
+		syncer := sync.UserSyncer{K8sClient: r.Client, UpdateLimiter: r.UserUpdateLimiter, Owner: client.ObjectKeyFromObject(instance), EventRecorder: r.Recorder}
+		removed, err := syncer.RemoveManagedKeys(ctx, targetLabel, targetAnnotation, lastInstance)
+		if err != nil {
+			r.recordEvent(instance, corev1.EventTypeWarning, "CleanupFailed", "Failed removing synced labels and annotations: %s", err.Error())
+			return err
+		}
+		log.FromContext(ctx).Info("Removed synced labels and annotations", "users", removed)
+		r.recordEvent(instance, corev1.EventTypeNormal, "CleanupCompleted", "Removed synced labels and annotations from %d users", removed)
+	}
+
+	controllerutil.RemoveFinalizer(instance, cleanupFinalizer)
+	return r.Client.Update(ctx, instance)
+}
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redhat-cop/operator-utils/pkg/util/apis"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/sync"
+)
+
+const (
+	fingerprintsKey = "fingerprints.json"
+	// fingerprintChunksKey is the number of ConfigMaps the fingerprints are split into, stored in the first ConfigMap
+	fingerprintChunksKey = "chunks"
+	// maxFingerprintsChunkSize is the maximum size of the fingerprints stored in a single ConfigMap, see maxConfigMapDataSize
+	maxFingerprintsChunkSize = maxConfigMapDataSize
+)
+
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+
+// loadFingerprints reads the fingerprints of the last synchronization. A missing or corrupt ConfigMap results in empty fingerprints.
+func (r *AttributeSyncReconciler) loadFingerprints(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject) (sync.Fingerprints, error) {
+	l := log.FromContext(ctx)
+	fingerprints := sync.Fingerprints{}
+
+	cm := &corev1.ConfigMap{}
+	err := r.Client.Get(ctx, fingerprintsChunkKey(r.resourceNamespace(instance), instance, 0), cm)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return fingerprints, nil
+		}
+		return nil, err
+	}
+	chunks := 1
+	if n, ok := cm.Data[fingerprintChunksKey]; ok {
+		chunks, err = strconv.Atoi(n)
+		if err != nil || chunks < 1 {
+			l.Info("ignoring fingerprints with invalid number of chunks", "configmap", cm.Name, "chunks", n)
+			return sync.Fingerprints{}, nil
+		}
+	}
+
+	for i := 0; i < chunks; i++ {
+		if i > 0 {
+			cm = &corev1.ConfigMap{}
+			if err := r.Client.Get(ctx, fingerprintsChunkKey(r.resourceNamespace(instance), instance, i), cm); err != nil {
+				if apierrors.IsNotFound(err) {
+					l.Info("ignoring incomplete fingerprints", "configmap", instance.GetFingerprintsConfigMapName(), "missing", i)
+					return sync.Fingerprints{}, nil
+				}
+				return nil, err
+			}
+		}
+		if data, ok := cm.Data[fingerprintsKey]; ok {
+			chunk := sync.Fingerprints{}
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				l.Error(err, "ignoring corrupt fingerprints", "configmap", cm.Name)
+				return sync.Fingerprints{}, nil
+			}
+			for username, fp := range chunk {
+				fingerprints[username] = fp
+			}
+		}
+	}
+	return fingerprints, nil
+}
+
+// saveFingerprints stores the fingerprints in the fingerprints ConfigMap. Fingerprints exceeding the size of a single ConfigMap
+// are split into additional ConfigMaps named `<name>-1`, `<name>-2` and so on. The first ConfigMap is written last, as its number
+// of chunks makes the new fingerprints visible. ConfigMaps of chunks no longer needed are deleted.
+func (r *AttributeSyncReconciler) saveFingerprints(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject, fingerprints sync.Fingerprints) error {
+	chunks, err := chunkFingerprints(fingerprints, maxFingerprintsChunkSize)
+	if err != nil {
+		return err
+	}
+
+	namespace := r.resourceNamespace(instance)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		key := fingerprintsChunkKey(namespace, instance, i)
+		cm := &corev1.ConfigMap{}
+		cm.Name = key.Name
+		cm.Namespace = key.Namespace
+		_, err = controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+			cm.Data = map[string]string{fingerprintsKey: chunks[i]}
+			if i == 0 {
+				cm.Data[fingerprintChunksKey] = strconv.Itoa(len(chunks))
+			}
+			return controllerutil.SetControllerReference(instance, cm, r.Scheme)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := len(chunks); ; i++ {
+		key := fingerprintsChunkKey(namespace, instance, i)
+		cm := &corev1.ConfigMap{}
+		cm.Name = key.Name
+		cm.Namespace = key.Namespace
+		if err := r.Client.Delete(ctx, cm); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+	}
+}
+
+// fingerprintsChunkKey returns the key of the ConfigMap storing the given chunk of the fingerprints.
+func fingerprintsChunkKey(namespace string, instance keycloakv1alpha1.AttributeSyncObject, chunk int) types.NamespacedName {
+	name := instance.GetFingerprintsConfigMapName()
+	if chunk > 0 {
+		name = fmt.Sprintf("%s-%d", name, chunk)
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}
+}
+
+// chunkFingerprints serializes the fingerprints ordered by username into JSON objects of at most maxSize bytes each.
+// There is always at least one chunk, so empty fingerprints are stored as well.
+func chunkFingerprints(fingerprints sync.Fingerprints, maxSize int) ([]string, error) {
+	usernames := make([]string, 0, len(fingerprints))
+	for username := range fingerprints {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	chunks := []string{}
+	chunk, size := sync.Fingerprints{}, 2
+	flush := func() error {
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, string(data))
+		chunk, size = sync.Fingerprints{}, 2
+		return nil
+	}
+	for _, username := range usernames {
+		entry, err := json.Marshal(map[string]string{username: fingerprints[username]})
+		if err != nil {
+			return nil, err
+		}
+		// Without the braces, plus a separating comma
+		entrySize := len(entry) - 1
+		if len(chunk) > 0 && size+entrySize > maxSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		chunk[username] = fingerprints[username]
+		size += entrySize
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// driftCorrectionDue returns true if an incremental synchronization should update all users regardless of their fingerprint.
+func driftCorrectionDue(instance keycloakv1alpha1.AttributeSyncObject, now time.Time) bool {
+	if instance.GetStatus().LastFullSyncTime == nil {
+		return true
+	}
+	cond, found := apis.GetCondition(apis.ReconcileSuccess, instance.GetConditions())
+	if !found || cond.ObservedGeneration != instance.GetGeneration() {
+		return true
+	}
+	return now.Sub(instance.GetStatus().LastFullSyncTime.Time) >= instance.GetFullSyncInterval()
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/sync"
+)
+
+var _ = Describe("Fingerprints", func() {
+	It("It should split large fingerprints into chunks", func() {
+		fingerprints := sync.Fingerprints{}
+		for i := 0; i < 1000; i++ {
+			fingerprints[fmt.Sprintf("user-%04d", i)] = "0123456789abcdef"
+		}
+
+		chunks, err := chunkFingerprints(fingerprints, 4096)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(len(chunks)).Should(BeNumerically(">", 1))
+
+		merged := sync.Fingerprints{}
+		for _, chunk := range chunks {
+			Expect(len(chunk)).Should(BeNumerically("<=", 4096))
+			Expect(json.Unmarshal([]byte(chunk), &merged)).Should(Succeed())
+		}
+		Expect(merged).Should(Equal(fingerprints))
+	})
+
+	It("It should store empty fingerprints in a single chunk", func() {
+		chunks, err := chunkFingerprints(sync.Fingerprints{}, 4096)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(chunks).Should(Equal([]string{"{}"}))
+	})
+})
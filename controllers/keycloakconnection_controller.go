@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+)
+
+// KeycloakConnectionReconciler checks the health of KeycloakConnection objects
+type KeycloakConnectionReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	KeycloakClientBuilder keycloakClientBuilder
+	// ReferencePolicy restricts the namespaces of referenced secrets
+	ReferencePolicy ReferencePolicy
+	// Sharder, if not nil, restricts the checked connections to the shards owned by this replica
+	Sharder *Sharder
+}
+
+//+kubebuilder:rbac:groups=keycloak.appuio.io,resources=keycloakconnections,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=keycloak.appuio.io,resources=keycloakconnections/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=keycloak.appuio.io,resources=keycloakconnections/finalizers,verbs=update
+
+// Reconcile authenticates to the Keycloak server of the connection and records the result in the status.
+// The connection is checked again after its check interval.
+func (r *KeycloakConnectionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+	l.Info("Checking connection")
+
+	conn := &keycloakv1alpha1.KeycloakConnection{}
+	if err := r.Client.Get(ctx, req.NamespacedName, conn); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !conn.ObjectMeta.DeletionTimestamp.IsZero() || !r.Sharder.Owns(conn) {
+		return ctrl.Result{}, nil
+	}
+
+	kc, err := keycloakClient(ctx, r.Client, r.KeycloakClientBuilder, r.ReferencePolicy, conn)
+	if err == nil {
+		err = kc.Ping(ctx)
+	}
+	if err != nil {
+		l.Error(err, "Connection check failed")
+	}
+
+	setConnectionConditions(conn, err)
+	conn.Status.LastCheckTime = &metav1.Time{Time: time.Now()}
+	if err := r.Client.Status().Update(ctx, conn); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: conn.GetCheckInterval()}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KeycloakConnectionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &keycloakv1alpha1.KeycloakConnection{}, secretRefIndex, indexConnectionSecretRefs)
+	if err != nil {
+		return err
+	}
+
+	bldr := ctrl.NewControllerManagedBy(mgr).
+		// Status updates of the periodic checks must not trigger another check
+		For(&keycloakv1alpha1.KeycloakConnection{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, shardLabelChangedPredicate()))).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(requestsFor(r.Client, &keycloakv1alpha1.KeycloakConnectionList{}, secretRefIndex)),
+			builder.WithPredicates(secretDataChangedPredicate()),
+		)
+	if r.Sharder != nil {
+		bldr = bldr.Watches(r.Sharder.Source(&keycloakv1alpha1.KeycloakConnectionList{}), &handler.EnqueueRequestForObject{})
+	}
+	return bldr.Complete(r)
+}
+
+// setConnectionConditions sets the CredentialsValid, KeycloakReachable and Ready conditions of the connection from the result of a check.
+func setConnectionConditions(conn *keycloakv1alpha1.KeycloakConnection, reason error) {
+	set := func(condType string, status metav1.ConditionStatus, condReason, message string) {
+		meta.SetStatusCondition(&conn.Status.Conditions, metav1.Condition{
+			Type:               condType,
+			Status:             status,
+			ObservedGeneration: conn.GetGeneration(),
+			Reason:             condReason,
+			Message:            message,
+		})
+	}
+
+	if reason == nil {
+		set(keycloakv1alpha1.ConditionCredentialsValid, metav1.ConditionTrue, keycloakv1alpha1.ReasonCredentialsValid, "")
+		set(keycloakv1alpha1.ConditionKeycloakReachable, metav1.ConditionTrue, keycloakv1alpha1.ReasonKeycloakReachable, "")
+		set(keycloakv1alpha1.ConditionReady, metav1.ConditionTrue, keycloakv1alpha1.ReasonReady, "")
+		return
+	}
+
+	condType, condReason := failureCondition(reason)
+	if condType == keycloakv1alpha1.ConditionCredentialsValid || condType == keycloakv1alpha1.ConditionKeycloakReachable {
+		setUncheckedConditions(set, condType)
+		set(condType, metav1.ConditionFalse, condReason, reason.Error())
+	} else {
+		setUncheckedConditions(set, "")
+	}
+	set(keycloakv1alpha1.ConditionReady, metav1.ConditionFalse, condReason, reason.Error())
+}
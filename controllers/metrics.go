@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/sync"
+)
+
+var (
+	syncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "keycloak_attribute_sync_duration_seconds",
+		Help:    "Duration of synchronization runs.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+	}, []string{"namespace", "name"})
+
+	lastSuccessfulSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "keycloak_attribute_sync_last_success_timestamp_seconds",
+		Help: "Unix timestamp of the last successful synchronization.",
+	}, []string{"namespace", "name"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(syncDuration, lastSuccessfulSync)
+}
+
+// deleteMetrics removes all metrics of the given AttributeSync.
+func deleteMetrics(key types.NamespacedName) {
+	syncDuration.DeleteLabelValues(key.Namespace, key.Name)
+	lastSuccessfulSync.DeleteLabelValues(key.Namespace, key.Name)
+	sync.DeleteMetrics(key)
+}
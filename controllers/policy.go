@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"fmt"
+)
+
+// Modes of the ReferencePolicy
+const (
+	// ReferencePolicyAllow allows references to secrets and connections in any namespace
+	ReferencePolicyAllow = "Allow"
+	// ReferencePolicyDeny only allows references to the namespace of the referencing object
+	ReferencePolicyDeny = "Deny"
+	// ReferencePolicyAllowlist allows references to the namespace of the referencing object and the allowed namespaces
+	ReferencePolicyAllowlist = "Allowlist"
+)
+
+// ReferencePolicy restricts the namespaces of the secrets and connections an AttributeSync or KeycloakConnection may reference.
+// Without a restriction, anyone allowed to create an AttributeSync can make the controller read the secrets of other namespaces.
+// References of a ClusterAttributeSync are checked against the cluster resource namespace.
+type ReferencePolicy struct {
+	// Mode is one of Allow, Deny or Allowlist. An empty mode allows all references.
+	Mode string
+	// AllowedNamespaces are the namespaces which can be referenced from any namespace if the mode is Allowlist
+	AllowedNamespaces []string
+}
+
+// Validate returns an error if the mode of the policy is unknown.
+func (p ReferencePolicy) Validate() error {
+	switch p.Mode {
+	case "", ReferencePolicyAllow, ReferencePolicyDeny, ReferencePolicyAllowlist:
+		return nil
+	default:
+		return fmt.Errorf("unknown reference policy %q, must be one of %s, %s or %s", p.Mode, ReferencePolicyAllow, ReferencePolicyDeny, ReferencePolicyAllowlist)
+	}
+}
+
+// check returns a referenceError if an object in namespace `from` may not reference the given object in namespace `to`.
+func (p ReferencePolicy) check(from, to, kind, name string) error {
+	if p.Mode == "" || p.Mode == ReferencePolicyAllow || from == to {
+		return nil
+	}
+	if p.Mode == ReferencePolicyAllowlist {
+		for _, ns := range p.AllowedNamespaces {
+			if ns == to {
+				return nil
+			}
+		}
+	}
+	return &referenceError{msg: fmt.Sprintf("%s '%s/%s' can't be referenced from namespace '%s'", kind, to, name, from)}
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/sync"
+)
+
+const (
+	reportKey = "report.json"
+	// maxConfigMapDataSize is the maximum size of the data written to a single ConfigMap, well below the 1 MiB limit of objects
+	maxConfigMapDataSize = 512 * 1024
+	// maxReportSize is the maximum size of the users listed in the report, see maxConfigMapDataSize
+	maxReportSize = maxConfigMapDataSize
+)
+
+// syncReport is the content of the report ConfigMap
+type syncReport struct {
+	// Time is the start time of the synchronization
+	Time metav1.Time `json:"time"`
+	// Error is the error the synchronization failed with, if any
+	Error string `json:"error,omitempty"`
+
+	*sync.Report
+}
+
+// saveReport writes the report of a synchronization to the report ConfigMap of the instance.
+func (r *AttributeSyncReconciler) saveReport(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject, report *sync.Report, start time.Time, syncErr error) error {
+	content := syncReport{Time: metav1.NewTime(start), Report: report}
+	if syncErr != nil {
+		content.Error = syncErr.Error()
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{}
+	cm.Name = instance.GetReportConfigMapName()
+	cm.Namespace = r.resourceNamespace(instance)
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.Data = map[string]string{reportKey: string(data)}
+		return controllerutil.SetControllerReference(instance, cm, r.Scheme)
+	})
+	return err
+}
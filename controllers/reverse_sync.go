@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+)
+
+// checkReverseSyncLoops returns a reverseSyncError if the reverse synchronization of the instance writes an attribute
+// which is synced to the cluster by the instance itself or another AttributeSync or ClusterAttributeSync of the same realm, which would make both directions fight.
+func (r *AttributeSyncReconciler) checkReverseSyncLoops(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject) error {
+	spec := instance.GetSpec()
+	rs := spec.ReverseSync
+	if rs == nil {
+		return nil
+	}
+	if rs.SourceLabel == "" && rs.SourceAnnotation == "" {
+		return &reverseSyncError{msg: "reverse synchronization requires a source label or annotation"}
+	}
+	if rs.SourceLabel != "" && rs.SourceAnnotation != "" {
+		return &reverseSyncError{msg: "reverse synchronization requires either a source label or annotation, not both"}
+	}
+	if rs.Attribute == spec.Attribute {
+		return &reverseSyncError{msg: fmt.Sprintf("attribute %q is synced in both directions", rs.Attribute)}
+	}
+	if (rs.SourceAnnotation != "" && rs.SourceAnnotation == spec.TargetAnnotation) ||
+		(rs.SourceLabel != "" && rs.SourceLabel == spec.TargetLabel) {
+		return &reverseSyncError{msg: "the reverse synchronization source is the target of the synchronization"}
+	}
+
+	url := r.keycloakURL(ctx, instance)
+	instances, err := r.listInstances(ctx)
+	if err != nil {
+		return err
+	}
+	for _, other := range instances {
+		if describe(other) == describe(instance) {
+			continue
+		}
+		if other.GetSpec().Realm == spec.Realm && other.GetSpec().Attribute == rs.Attribute && r.keycloakURL(ctx, other) == url {
+			return &reverseSyncError{msg: fmt.Sprintf("attribute %q is synced to the cluster by %s", rs.Attribute, describe(other))}
+		}
+	}
+	return nil
+}
+
+// keycloakURL returns the URL of the Keycloak server of the instance, or an empty string if its connection can't be fetched.
+func (r *AttributeSyncReconciler) keycloakURL(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject) string {
+	conn, err := r.connection(ctx, instance)
+	if err != nil {
+		return ""
+	}
+	return conn.Spec.URL
+}
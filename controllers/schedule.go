@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+)
+
+// schedule decides when the next full synchronization of an instance with a schedule is due
+type schedule struct {
+	cron cron.Schedule
+	// jitter is added to every time of the cron schedule
+	jitter time.Duration
+	// startingDeadline is the time after which a missed run is skipped, zero to never skip
+	startingDeadline time.Duration
+}
+
+// parseSchedule returns the schedule of the instance, or nil if no schedule is set.
+func parseSchedule(instance keycloakv1alpha1.AttributeSyncObject) (*schedule, error) {
+	spec := instance.GetSpec()
+	if spec.Schedule == "" {
+		return nil, nil
+	}
+	sched, err := parseCron(spec.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &schedule{cron: sched}
+	if spec.ScheduleJitter != nil && spec.ScheduleJitter.Duration > 0 {
+		h := fnv.New64a()
+		h.Write([]byte(client.ObjectKeyFromObject(instance).String()))
+		// Whole seconds, as the last synchronization time is stored with second precision
+		s.jitter = time.Duration(h.Sum64() % uint64(spec.ScheduleJitter.Duration)).Truncate(time.Second)
+	}
+	if spec.StartingDeadline != nil && spec.StartingDeadline.Duration > 0 {
+		s.startingDeadline = spec.StartingDeadline.Duration
+	}
+	return s, nil
+}
+
+// parseCron parses a standard cron expression with an optional `CRON_TZ=<zone>` or `TZ=<zone>` prefix.
+func parseCron(spec string) (cron.Schedule, error) {
+	var loc *time.Location
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.Index(spec, " ")
+		if i < 0 {
+			return nil, fmt.Errorf("missing cron expression after time zone in %q", spec)
+		}
+		var err error
+		loc, err = time.LoadLocation(spec[strings.Index(spec, "=")+1 : i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone: %w", err)
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+
+	sched, err := cron.ParseStandard(spec)
+	if err != nil || loc == nil {
+		return sched, err
+	}
+	return locationSchedule{Schedule: sched, loc: loc}, nil
+}
+
+// locationSchedule evaluates a cron schedule in a fixed time zone instead of the time zone of the given time
+type locationSchedule struct {
+	cron.Schedule
+	loc *time.Location
+}
+
+func (s locationSchedule) Next(t time.Time) time.Time {
+	return s.Schedule.Next(t.In(s.loc))
+}
+
+// next returns the first run after t.
+func (s *schedule) next(t time.Time) time.Time {
+	return s.cron.Next(t.Add(-s.jitter)).Add(s.jitter)
+}
+
+// nextRun returns the time of the next full synchronization after the last one.
+// The returned time is not after now if a run is due. If all runs since the last synchronization were
+// missed by more than the starting deadline, they are skipped and the first run after now is returned.
+func (s *schedule) nextRun(lastSync, now time.Time) time.Time {
+	next := s.next(lastSync)
+	if next.After(now) || s.startingDeadline == 0 {
+		return next
+	}
+	if s.next(now.Add(-s.startingDeadline)).After(now) {
+		return s.next(now)
+	}
+	return next
+}
+
+// fullSyncDue returns true if all users need to be synced, either because the instance was never synced successfully,
+// its spec changed since the last synchronization, or the schedule is due.
+func fullSyncDue(instance keycloakv1alpha1.AttributeSyncObject, sched *schedule, now time.Time) bool {
+	status := instance.GetStatus()
+	if status.LastSyncTime == nil || !meta.IsStatusConditionTrue(status.Conditions, keycloakv1alpha1.ConditionSynced) {
+		return true
+	}
+	cond := meta.FindStatusCondition(status.Conditions, keycloakv1alpha1.ConditionSynced)
+	if cond.ObservedGeneration != instance.GetGeneration() {
+		return true
+	}
+	if sched == nil {
+		return false
+	}
+	return !sched.nextRun(status.LastSyncTime.Time, now).After(now)
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"github.com/Nerzal/gocloak/v9"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	userv1 "github.com/openshift/api/user/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/keycloak"
+)
+
+var _ = Describe("AttributeSync schedule", func() {
+	newInstance := func(spec keycloakv1alpha1.AttributeSyncSpec) *keycloakv1alpha1.AttributeSync {
+		return &keycloakv1alpha1.AttributeSync{
+			ObjectMeta: metav1.ObjectMeta{Name: "sync-organization", Namespace: "default"},
+			Spec:       spec,
+		}
+	}
+	mustParse := func(spec keycloakv1alpha1.AttributeSyncSpec) *schedule {
+		sched, err := parseSchedule(newInstance(spec))
+		Expect(err).ShouldNot(HaveOccurred())
+		return sched
+	}
+
+	It("It should evaluate CRON_TZ schedules in the given time zone", func() {
+		sched := mustParse(keycloakv1alpha1.AttributeSyncSpec{Schedule: "CRON_TZ=Europe/Zurich 0 2 * * *"})
+
+		next := sched.next(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+		Expect(next).Should(BeTemporally("==", time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC)))
+	})
+
+	It("It should reject unknown time zones", func() {
+		_, err := parseSchedule(newInstance(keycloakv1alpha1.AttributeSyncSpec{Schedule: "CRON_TZ=Mars/Olympus 0 2 * * *"}))
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("It should delay runs by a stable jitter", func() {
+		spec := keycloakv1alpha1.AttributeSyncSpec{Schedule: "0 * * * *", ScheduleJitter: &metav1.Duration{Duration: 10 * time.Minute}}
+		sched := mustParse(spec)
+		Expect(sched.jitter).Should(BeNumerically("<", 10*time.Minute))
+		Expect(mustParse(spec).jitter).Should(Equal(sched.jitter))
+
+		lastSync := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC).Add(sched.jitter)
+		Expect(sched.nextRun(lastSync, lastSync)).Should(BeTemporally("==", lastSync.Add(time.Hour)))
+	})
+
+	It("It should base the next run on the last synchronization", func() {
+		sched := mustParse(keycloakv1alpha1.AttributeSyncSpec{Schedule: "0 * * * *"})
+		lastSync := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+		Expect(sched.nextRun(lastSync, lastSync.Add(30*time.Minute))).Should(BeTemporally("==", lastSync.Add(time.Hour)))
+		By("By catching up missed runs with a single run")
+		Expect(sched.nextRun(lastSync, lastSync.Add(5*time.Hour+30*time.Minute))).Should(BeTemporally("==", lastSync.Add(time.Hour)))
+	})
+
+	It("It should skip runs missed by more than the starting deadline", func() {
+		sched := mustParse(keycloakv1alpha1.AttributeSyncSpec{Schedule: "0 * * * *", StartingDeadline: &metav1.Duration{Duration: 10 * time.Minute}})
+		lastSync := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+		Expect(sched.nextRun(lastSync, lastSync.Add(time.Hour+5*time.Minute))).Should(BeTemporally("==", lastSync.Add(time.Hour)))
+		Expect(sched.nextRun(lastSync, lastSync.Add(5*time.Hour+30*time.Minute))).Should(BeTemporally("==", lastSync.Add(6*time.Hour)))
+	})
+})
+
+var _ = Describe("Scheduled synchronization", func() {
+	const (
+		attribute = "example.com/organization"
+		label     = "example.com/organization"
+	)
+
+	It("It should sync all users once a referenced secret changes", func() {
+		ctx := context.Background()
+
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).Should(Succeed())
+		Expect(keycloakv1alpha1.AddToScheme(s)).Should(Succeed())
+		Expect(userv1.AddToScheme(s)).Should(Succeed())
+
+		instance := &keycloakv1alpha1.AttributeSync{
+			ObjectMeta: metav1.ObjectMeta{Name: "sync-organization", Namespace: "default"},
+			Spec: keycloakv1alpha1.AttributeSyncSpec{
+				URL:               "https://keycloak.example.com",
+				Attribute:         attribute,
+				TargetLabel:       label,
+				Schedule:          "0 0 1 1 *",
+				CredentialsSecret: corev1.SecretReference{Name: "credentials"},
+			},
+		}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: "default"},
+			Data:       map[string][]byte{"username": []byte("user"), "password": []byte("pw")},
+		}
+		c := fake.NewClientBuilder().WithScheme(s).WithObjects(
+			instance, secret,
+			&userv1.User{ObjectMeta: metav1.ObjectMeta{Name: "alice"}},
+		).Build()
+		kc := &keycloak.FakeClient{Users: []*gocloak.User{keycloak.UserWithAttribute("alice", attribute, "IgniteCyber")}}
+		r := &AttributeSyncReconciler{
+			Client: c,
+			Scheme: s,
+			KeycloakClientBuilder: func(string, string, string, string, *tls.Config, keycloak.TransportOptions) keycloak.Client {
+				return kc
+			},
+			snapshots: newSnapshotCache(),
+		}
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+		label := func() string {
+			user := &userv1.User{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "alice"}, user)).Should(Succeed())
+			return user.Labels[label]
+		}
+
+		By("By syncing the new sync config")
+		_, err := r.Reconcile(ctx, req)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(label()).Should(Equal("IgniteCyber"))
+
+		By("By skipping reconciles before the next scheduled run")
+		Expect(kc.FakeClientSetUserAttribute("alice", attribute, "Blockchain")).Should(Succeed())
+		_, err = r.Reconcile(ctx, req)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(label()).Should(Equal("IgniteCyber"))
+
+		By("By rotating the credentials")
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(secret), secret)).Should(Succeed())
+		secret.Data["password"] = []byte("rotated")
+		Expect(c.Update(ctx, secret)).Should(Succeed())
+		_, err = r.Reconcile(ctx, req)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(label()).Should(Equal("Blockchain"))
+	})
+})
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+)
+
+// secretRefIndex indexes AttributeSync, ClusterAttributeSync and KeycloakConnection objects by the `namespace/name` of the secrets they reference
+const secretRefIndex = "spec.secretRefs"
+
+// connectionRefIndex indexes AttributeSync and ClusterAttributeSync objects by the `namespace/name` of the KeycloakConnection they reference
+const connectionRefIndex = "spec.connectionRef"
+
+// indexSecretRefs indexes the secrets of the connection details set in the spec of an AttributeSync or ClusterAttributeSync.
+// Secrets of a referenced KeycloakConnection are indexed on the connection instead.
+func (r *AttributeSyncReconciler) indexSecretRefs(obj client.Object) []string {
+	instance, ok := obj.(keycloakv1alpha1.AttributeSyncObject)
+	if !ok || instance.GetSpec().ConnectionRef != nil {
+		return nil
+	}
+	return connectionSecretRefs(instance.GetSpec().InlineConnection(r.resourceNamespace(instance)))
+}
+
+func (r *AttributeSyncReconciler) indexConnectionRef(obj client.Object) []string {
+	instance, ok := obj.(keycloakv1alpha1.AttributeSyncObject)
+	if !ok || instance.GetSpec().ConnectionRef == nil {
+		return nil
+	}
+	return []string{r.connectionKey(instance).String()}
+}
+
+func indexConnectionSecretRefs(obj client.Object) []string {
+	conn, ok := obj.(*keycloakv1alpha1.KeycloakConnection)
+	if !ok {
+		return nil
+	}
+	return connectionSecretRefs(conn)
+}
+
+func connectionSecretRefs(conn *keycloakv1alpha1.KeycloakConnection) []string {
+	keys := connectionSecretKeys(conn)
+	refs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		refs = append(refs, key.String())
+	}
+	return refs
+}
+
+// connectionSecretKeys returns the keys of the credentials secret and, if set, the CA and client certificate secrets of the connection.
+func connectionSecretKeys(conn *keycloakv1alpha1.KeycloakConnection) []types.NamespacedName {
+	creds := conn.GetCredentialsSecret()
+	keys := []types.NamespacedName{{Namespace: creds.Namespace, Name: creds.Name}}
+	if ca := conn.GetCaSecret(); ca != nil {
+		keys = append(keys, types.NamespacedName{Namespace: ca.Namespace, Name: ca.Name})
+	}
+	if cert := conn.GetClientCertSecret(); cert != nil {
+		keys = append(keys, types.NamespacedName{Namespace: cert.Namespace, Name: cert.Name})
+	}
+	return keys
+}
+
+// referencesHash returns a hash of the versions of the KeycloakConnection and secrets referenced by the instance.
+// Of the connection only the generation is hashed, as its status is updated on every check.
+// Objects which can't be read are hashed as missing, so creating them changes the hash as well.
+func (r *AttributeSyncReconciler) referencesHash(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject) string {
+	conn, err := r.connection(ctx, instance)
+	if err != nil {
+		return ""
+	}
+	h := fnv.New64a()
+	if instance.GetSpec().ConnectionRef != nil {
+		fmt.Fprintf(h, "%s/%s@%d;", conn.Namespace, conn.Name, conn.Generation)
+	}
+	for _, key := range connectionSecretKeys(conn) {
+		secret := &corev1.Secret{}
+		if err := r.Client.Get(ctx, key, secret); err != nil {
+			fmt.Fprintf(h, "%s;", key)
+			continue
+		}
+		fmt.Fprintf(h, "%s@%s;", key, secret.ResourceVersion)
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// requestsFor returns a function mapping an object to a request for every object of the list type whose index matches the `namespace/name` of the object
+func requestsFor(c client.Client, list client.ObjectList, index string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		list := list.DeepCopyObject().(client.ObjectList)
+		err := c.List(context.Background(), list, client.MatchingFields{index: client.ObjectKeyFromObject(obj).String()})
+		if err != nil {
+			log.Log.Error(err, "unable to list referencing objects", "index", index, "object", client.ObjectKeyFromObject(obj))
+			return nil
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			log.Log.Error(err, "unable to list referencing objects", "index", index, "object", client.ObjectKeyFromObject(obj))
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(items))
+		for _, item := range items {
+			if o, ok := item.(client.Object); ok {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(o)})
+			}
+		}
+		return requests
+	}
+}
+
+// secretDataChangedPredicate ignores secret updates not changing the data, such as metadata changes.
+func secretDataChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldSecret, ok := e.ObjectOld.(*corev1.Secret)
+			if !ok {
+				return false
+			}
+			newSecret, ok := e.ObjectNew.(*corev1.Secret)
+			if !ok {
+				return false
+			}
+			return !reflect.DeepEqual(oldSecret.Data, newSecret.Data)
+		},
+	}
+}
+
+// connectionChangedPredicate ignores KeycloakConnection updates changing neither the spec nor the readiness.
+// Synchronizations are retried once a connection becomes ready, but not on every check of the connection.
+func connectionChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldConn, ok := e.ObjectOld.(*keycloakv1alpha1.KeycloakConnection)
+			if !ok {
+				return false
+			}
+			newConn, ok := e.ObjectNew.(*keycloakv1alpha1.KeycloakConnection)
+			if !ok {
+				return false
+			}
+			return oldConn.GetGeneration() != newConn.GetGeneration() ||
+				meta.IsStatusConditionTrue(oldConn.Status.Conditions, keycloakv1alpha1.ConditionReady) != meta.IsStatusConditionTrue(newConn.Status.Conditions, keycloakv1alpha1.ConditionReady)
+		},
+	}
+}
@@ -0,0 +1,427 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	gosync "sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+)
+
+// Labels of the leases of a Sharder
+const (
+	// shardGroupLabel is set to the name of the Sharder on all of its leases
+	shardGroupLabel = "attributesync.keycloak.appuio.io/shard-group"
+	// shardLeaseLabel is set to the number of the shard on shard leases
+	shardLeaseLabel = "attributesync.keycloak.appuio.io/shard-lease"
+	// memberLeaseLabel is set on the leases announcing a replica
+	memberLeaseLabel = "attributesync.keycloak.appuio.io/member-lease"
+)
+
+// Sharder distributes AttributeSyncs, ClusterAttributeSyncs and KeycloakConnections across controller replicas.
+// Every object belongs to one of Shards shards, by the shard label or the hash of its namespace and name.
+// A replica owns a shard as long as it holds the Lease of the shard. Every replica also renews a member Lease and claims
+// at most its fair share of the shards, so the shards are spread evenly and taken over once a replica stops renewing its leases.
+type Sharder struct {
+	// Client writes the leases and lists the objects of newly claimed shards
+	Client client.Client
+	// Reader reads the leases. It should not be cached, as the leases of other replicas change constantly.
+	Reader client.Reader
+
+	// Namespace of the leases
+	Namespace string
+	// Name is the prefix of the lease names
+	Name string
+	// Identity of this replica, must be unique among all replicas
+	Identity string
+	// Shards is the number of shards
+	Shards int
+	// LeaseDuration is the time after which the lease of a replica which stopped renewing it can be taken over. Defaults to 15s.
+	LeaseDuration time.Duration
+	// RenewInterval is the interval in which the leases are renewed and free shards are claimed. Defaults to 5s.
+	RenewInterval time.Duration
+
+	mu gosync.RWMutex
+	// owned are the owned shards and the time until which their lease is valid
+	owned   map[int]time.Time
+	sources []shardSource
+	// members is the number of replicas counted by the last sync
+	members int
+}
+
+// shardSource emits an event for every object of the list type in a newly claimed or lost shard
+type shardSource struct {
+	list   client.ObjectList
+	events chan event.GenericEvent
+}
+
+// Source returns a source emitting an event for every object of the list type in a shard once it is claimed,
+// as the objects are ignored by the controller until then, and once it is lost, so the controller can drop the state it keeps about them.
+// Must be called before the Sharder is started.
+func (s *Sharder) Source(list client.ObjectList) source.Source {
+	events := make(chan event.GenericEvent)
+	s.sources = append(s.sources, shardSource{list: list, events: events})
+	return &source.Channel{Source: events}
+}
+
+// NeedLeaderElection returns false, as all replicas claim shards.
+func (s *Sharder) NeedLeaderElection() bool {
+	return false
+}
+
+// Start claims and renews shards until the context is done. The leases of all owned shards are released on return.
+func (s *Sharder) Start(ctx context.Context) error {
+	if s.LeaseDuration == 0 {
+		s.LeaseDuration = 15 * time.Second
+	}
+	if s.RenewInterval == 0 {
+		s.RenewInterval = 5 * time.Second
+	}
+
+	ticker := time.NewTicker(s.RenewInterval)
+	defer ticker.Stop()
+	for {
+		s.sync(ctx)
+		select {
+		case <-ctx.Done():
+			s.releaseAll(ctx)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Owns returns true if the object belongs to a shard owned by this replica. A nil Sharder owns all objects.
+func (s *Sharder) Owns(obj client.Object) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	validUntil, ok := s.owned[s.shardOf(obj)]
+	return ok && time.Now().Before(validUntil)
+}
+
+// shardOf returns the shard of the object from the shard label or the hash of its namespace and name.
+func (s *Sharder) shardOf(obj client.Object) int {
+	if shard, err := strconv.Atoi(obj.GetLabels()[keycloakv1alpha1.ShardLabel]); err == nil && shard >= 0 && shard < s.Shards {
+		return shard
+	}
+	h := fnv.New32a()
+	h.Write([]byte(client.ObjectKeyFromObject(obj).String()))
+	return int(h.Sum32() % uint32(s.Shards))
+}
+
+// shardLabelChangedPredicate accepts updates changing the shard label, which moves the object to another shard.
+// The replica owning the new shard doesn't pick up the object without an event.
+func shardLabelChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return e.ObjectOld.GetLabels()[keycloakv1alpha1.ShardLabel] != e.ObjectNew.GetLabels()[keycloakv1alpha1.ShardLabel]
+		},
+	}
+}
+
+// sync renews the member lease and the leases of the owned shards, releases shards above the fair share and claims free shards up to it.
+func (s *Sharder) sync(ctx context.Context) {
+	l := log.FromContext(ctx).WithName("sharder")
+	now := time.Now()
+
+	memberErr := s.renewMember(ctx, now)
+	if memberErr != nil {
+		l.Error(memberErr, "unable to renew member lease")
+	}
+
+	leases := &coordinationv1.LeaseList{}
+	if err := s.Reader.List(ctx, leases, client.InNamespace(s.Namespace), client.MatchingLabels{shardGroupLabel: s.Name}); err != nil {
+		// Owned shards stay owned until their leases expire
+		l.Error(err, "unable to list leases")
+		return
+	}
+	members := 0
+	selfCounted := false
+	shardLeases := map[int]*coordinationv1.Lease{}
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		if _, ok := lease.Labels[memberLeaseLabel]; ok {
+			if leaseValid(lease, now) {
+				members++
+				selfCounted = selfCounted || lease.Name == s.memberLeaseName()
+			} else if leaseExpiredFor(lease, now, 10*s.LeaseDuration) {
+				// Replicas get new identities when they are replaced, clean up after them
+				if err := s.Client.Delete(ctx, lease); client.IgnoreNotFound(err) != nil {
+					l.Error(err, "unable to delete expired member lease", "lease", lease.Name)
+				}
+			}
+			continue
+		}
+		if shard, err := strconv.Atoi(lease.Labels[shardLeaseLabel]); err == nil && shard >= 0 && shard < s.Shards {
+			shardLeases[shard] = lease
+		}
+	}
+	if !selfCounted {
+		members++
+	}
+	if memberErr != nil && members < s.members {
+		// Without its own member lease, the other replicas may not count this one. Keep the last known number of replicas,
+		// so a replica failing to renew its lease doesn't claim more than its share of the shards.
+		members = s.members
+	}
+	s.members = members
+	fairShare := (s.Shards + members - 1) / members
+
+	held := []int{}
+	for shard := 0; shard < s.Shards; shard++ {
+		if lease := shardLeases[shard]; lease != nil && leaseHolder(lease) == s.Identity && leaseValid(lease, now) {
+			held = append(held, shard)
+		}
+	}
+	for len(held) > fairShare {
+		shard := held[len(held)-1]
+		held = held[:len(held)-1]
+		l.Info("Releasing shard above fair share", "shard", shard, "fairShare", fairShare)
+		s.disown(shard)
+		s.enqueueShard(ctx, shard)
+		if err := s.release(ctx, shardLeases[shard]); err != nil {
+			l.Error(err, "unable to release shard", "shard", shard)
+		}
+	}
+
+	renewed := make([]int, 0, fairShare)
+	for _, shard := range held {
+		if err := s.renew(ctx, shardLeases[shard], now); err != nil {
+			l.Error(err, "unable to renew shard lease", "shard", shard)
+			continue
+		}
+		renewed = append(renewed, shard)
+		s.own(shard, now)
+	}
+
+	for shard := 0; shard < s.Shards && len(held) < fairShare; shard++ {
+		lease := shardLeases[shard]
+		if lease != nil && leaseHolder(lease) != "" && leaseValid(lease, now) {
+			continue
+		}
+		if err := s.claim(ctx, shard, lease, now); err != nil {
+			// Most likely another replica claimed the shard first
+			l.V(1).Info("unable to claim shard", "shard", shard, "error", err.Error())
+			continue
+		}
+		l.Info("Claimed shard", "shard", shard)
+		held = append(held, shard)
+		s.own(shard, now)
+		s.enqueueShard(ctx, shard)
+	}
+
+	for _, shard := range s.expired(now) {
+		// The lease couldn't be renewed in time, another replica may already have taken over the shard
+		l.Info("Lost shard", "shard", shard)
+		s.disown(shard)
+		s.enqueueShard(ctx, shard)
+	}
+}
+
+// renewMember creates or renews the member lease of this replica.
+func (s *Sharder) renewMember(ctx context.Context, now time.Time) error {
+	lease := &coordinationv1.Lease{}
+	err := s.Reader.Get(ctx, client.ObjectKey{Namespace: s.Namespace, Name: s.memberLeaseName()}, lease)
+	if apierrors.IsNotFound(err) {
+		lease = s.newLease(s.memberLeaseName(), map[string]string{memberLeaseLabel: s.Identity})
+		s.hold(lease, now)
+		return s.Client.Create(ctx, lease)
+	}
+	if err != nil {
+		return err
+	}
+	s.hold(lease, now)
+	return s.Client.Update(ctx, lease)
+}
+
+// claim takes over the lease of the shard, or creates it if it doesn't exist yet.
+// Claiming fails with a conflict if another replica changed the lease since it was listed.
+func (s *Sharder) claim(ctx context.Context, shard int, lease *coordinationv1.Lease, now time.Time) error {
+	if lease == nil {
+		lease = s.newLease(fmt.Sprintf("%s-shard-%d", s.Name, shard), map[string]string{shardLeaseLabel: strconv.Itoa(shard)})
+		s.hold(lease, now)
+		return s.Client.Create(ctx, lease)
+	}
+	lease = lease.DeepCopy()
+	transitions := int32(1)
+	if lease.Spec.LeaseTransitions != nil {
+		transitions = *lease.Spec.LeaseTransitions + 1
+	}
+	s.hold(lease, now)
+	lease.Spec.LeaseTransitions = &transitions
+	return s.Client.Update(ctx, lease)
+}
+
+func (s *Sharder) renew(ctx context.Context, lease *coordinationv1.Lease, now time.Time) error {
+	lease = lease.DeepCopy()
+	renewTime := metav1.NewMicroTime(now)
+	lease.Spec.RenewTime = &renewTime
+	return s.Client.Update(ctx, lease)
+}
+
+// release clears the holder of the lease, so other replicas can claim the shard without waiting for the lease to expire.
+func (s *Sharder) release(ctx context.Context, lease *coordinationv1.Lease) error {
+	lease = lease.DeepCopy()
+	lease.Spec.HolderIdentity = nil
+	return s.Client.Update(ctx, lease)
+}
+
+// releaseAll releases the leases of all owned shards and removes the member lease, used when the replica shuts down.
+// The remaining replicas take over the shards without waiting for the leases to expire.
+// The given context is only used for logging, as the context of the manager is already done on shutdown.
+func (s *Sharder) releaseAll(ctx context.Context) {
+	l := log.FromContext(ctx).WithName("sharder")
+
+	s.mu.Lock()
+	owned := make([]int, 0, len(s.owned))
+	for shard := range s.owned {
+		owned = append(owned, shard)
+	}
+	s.owned = nil
+	s.mu.Unlock()
+	sort.Ints(owned)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.RenewInterval)
+	defer cancel()
+	for _, shard := range owned {
+		lease := &coordinationv1.Lease{}
+		if err := s.Reader.Get(ctx, client.ObjectKey{Namespace: s.Namespace, Name: fmt.Sprintf("%s-shard-%d", s.Name, shard)}, lease); err != nil {
+			l.Error(err, "unable to release shard", "shard", shard)
+			continue
+		}
+		if leaseHolder(lease) != s.Identity {
+			continue
+		}
+		if err := s.release(ctx, lease); err != nil {
+			l.Error(err, "unable to release shard", "shard", shard)
+		}
+	}
+	if err := s.Client.Delete(ctx, s.newLease(s.memberLeaseName(), map[string]string{})); client.IgnoreNotFound(err) != nil {
+		l.Error(err, "unable to delete member lease")
+	}
+}
+
+// enqueueShard emits an event for every object of the shard on all sources.
+func (s *Sharder) enqueueShard(ctx context.Context, shard int) {
+	for _, src := range s.sources {
+		list := src.list.DeepCopyObject().(client.ObjectList)
+		if err := s.Client.List(ctx, list); err != nil {
+			log.FromContext(ctx).Error(err, "unable to list objects of claimed shard", "shard", shard)
+			continue
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "unable to list objects of claimed shard", "shard", shard)
+			continue
+		}
+		objs := make([]client.Object, 0, len(items))
+		for _, item := range items {
+			if obj, ok := item.(client.Object); ok && s.shardOf(obj) == shard {
+				objs = append(objs, obj)
+			}
+		}
+		// Sending blocks until the controller started, which must not delay renewing the leases
+		go func(events chan<- event.GenericEvent) {
+			for _, obj := range objs {
+				select {
+				case events <- event.GenericEvent{Object: obj}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(src.events)
+	}
+}
+
+func (s *Sharder) own(shard int, renewed time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owned == nil {
+		s.owned = map[int]time.Time{}
+	}
+	s.owned[shard] = renewed.Add(s.LeaseDuration)
+}
+
+func (s *Sharder) disown(shard int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.owned, shard)
+}
+
+// expired returns the owned shards whose lease is no longer valid.
+func (s *Sharder) expired(now time.Time) []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	shards := []int{}
+	for shard, validUntil := range s.owned {
+		if !now.Before(validUntil) {
+			shards = append(shards, shard)
+		}
+	}
+	sort.Ints(shards)
+	return shards
+}
+
+func (s *Sharder) memberLeaseName() string {
+	return s.Name + "-member-" + s.Identity
+}
+
+func (s *Sharder) newLease(name string, labels map[string]string) *coordinationv1.Lease {
+	labels[shardGroupLabel] = s.Name
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: s.Namespace,
+			Labels:    labels,
+		},
+	}
+}
+
+// hold sets this replica as the holder of the lease.
+func (s *Sharder) hold(lease *coordinationv1.Lease, now time.Time) {
+	identity := s.Identity
+	duration := int32(s.LeaseDuration.Seconds())
+	renewTime := metav1.NewMicroTime(now)
+	lease.Spec.HolderIdentity = &identity
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.AcquireTime = &renewTime
+	lease.Spec.RenewTime = &renewTime
+}
+
+func leaseHolder(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+// leaseValid returns true if the lease was renewed within its duration.
+func leaseValid(lease *coordinationv1.Lease, now time.Time) bool {
+	return !leaseExpiredFor(lease, now, 0)
+}
+
+// leaseExpiredFor returns true if the lease expired at least the given time ago.
+func leaseExpiredFor(lease *coordinationv1.Lease, now time.Time, d time.Duration) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return !now.Before(expiry.Add(d))
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+)
+
+var _ = Describe("Sharder", func() {
+	newSharder := func(identity string) *Sharder {
+		return &Sharder{
+			Client:        k8sClient,
+			Reader:        k8sClient,
+			Namespace:     "default",
+			Name:          "sharding-test",
+			Identity:      identity,
+			Shards:        4,
+			LeaseDuration: time.Minute,
+			RenewInterval: time.Second,
+		}
+	}
+	ownedShards := func(s *Sharder) int {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return len(s.owned)
+	}
+
+	It("It should assign objects to shards by label or hash", func() {
+		s := newSharder("replica-a")
+		obj := &keycloakv1alpha1.AttributeSync{ObjectMeta: metav1.ObjectMeta{Name: "sync-organization", Namespace: "default"}}
+		Expect(s.shardOf(obj)).Should(Equal(s.shardOf(obj.DeepCopy())))
+
+		obj.Labels = map[string]string{keycloakv1alpha1.ShardLabel: "3"}
+		Expect(s.shardOf(obj)).Should(Equal(3))
+		Expect((*Sharder)(nil).Owns(obj)).Should(BeTrue())
+		Expect(s.Owns(obj)).Should(BeFalse())
+	})
+
+	It("It should pass updates moving objects to another shard", func() {
+		old := &keycloakv1alpha1.AttributeSync{ObjectMeta: metav1.ObjectMeta{Name: "sync-organization", Namespace: "default", Generation: 1}}
+		moved := old.DeepCopy()
+		moved.Labels = map[string]string{keycloakv1alpha1.ShardLabel: "3"}
+		Expect(instanceChangedPredicate().Update(event.UpdateEvent{ObjectOld: old, ObjectNew: moved})).Should(BeTrue())
+
+		By("By ignoring status updates")
+		updated := old.DeepCopy()
+		updated.Status.PlannedChangesCount = 1
+		Expect(instanceChangedPredicate().Update(event.UpdateEvent{ObjectOld: old, ObjectNew: updated})).Should(BeFalse())
+	})
+
+	It("It should spread the shards across replicas", func() {
+		ctx := context.Background()
+		a, b := newSharder("replica-a"), newSharder("replica-b")
+
+		a.sync(ctx)
+		Expect(ownedShards(a)).Should(Equal(4))
+
+		By("By releasing shards above the fair share once another replica joins")
+		b.sync(ctx)
+		a.sync(ctx)
+		b.sync(ctx)
+		Expect(ownedShards(a)).Should(Equal(2))
+		Expect(ownedShards(b)).Should(Equal(2))
+
+		By("By taking over the shards of a stopped replica")
+		a.releaseAll(ctx)
+		Expect(ownedShards(a)).Should(Equal(0))
+		b.sync(ctx)
+		Expect(ownedShards(b)).Should(Equal(4))
+		b.releaseAll(ctx)
+	})
+})
+
+var _ = Describe("Sharded reconciliation", func() {
+	It("It should drop the metrics and snapshots of instances in a released shard", func() {
+		ctx := context.Background()
+
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).Should(Succeed())
+		Expect(keycloakv1alpha1.AddToScheme(s)).Should(Succeed())
+		instance := &keycloakv1alpha1.AttributeSync{ObjectMeta: metav1.ObjectMeta{
+			Name:      "sync-sharded",
+			Namespace: "default",
+			Labels:    map[string]string{keycloakv1alpha1.ShardLabel: "1"},
+		}}
+		c := fake.NewClientBuilder().WithScheme(s).WithObjects(instance).Build()
+		newSharder := func(identity string) *Sharder {
+			return &Sharder{
+				Client:        c,
+				Reader:        c,
+				Namespace:     "default",
+				Name:          "sharding-test",
+				Identity:      identity,
+				Shards:        2,
+				LeaseDuration: time.Minute,
+				RenewInterval: time.Second,
+			}
+		}
+		a, b := newSharder("replica-a"), newSharder("replica-b")
+		a.Source(&keycloakv1alpha1.AttributeSyncList{})
+		events := a.sources[0].events
+		r := &AttributeSyncReconciler{Client: c, Scheme: s, Sharder: a, snapshots: newSnapshotCache()}
+		key := client.ObjectKeyFromObject(instance)
+
+		By("By owning the shard of the instance")
+		a.sync(ctx)
+		Eventually(events).Should(Receive())
+		Expect(a.Owns(instance)).Should(BeTrue())
+		lastSuccessfulSync.WithLabelValues(key.Namespace, key.Name).SetToCurrentTime()
+		r.snapshots.get(key, "example.com/organization", "").RecordValue("alice", "IgniteCyber")
+
+		By("By releasing the shard once another replica joins")
+		b.sync(ctx)
+		a.sync(ctx)
+		var e event.GenericEvent
+		Eventually(events).Should(Receive(&e))
+		Expect(client.ObjectKeyFromObject(e.Object)).Should(Equal(key))
+		Expect(a.Owns(instance)).Should(BeFalse())
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(lastSuccessfulSync.DeleteLabelValues(key.Namespace, key.Name)).Should(BeFalse())
+		Expect(r.snapshots.recorded(key, "example.com/organization", "", "alice")).Should(BeFalse())
+	})
+})
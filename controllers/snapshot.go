@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	gosync "sync"
+
+	userv1 "github.com/openshift/api/user/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/sync"
+)
+
+// snapshotCache keeps the attribute values last seen in Keycloak for every AttributeSync.
+// It is used to correct drift on OpenShift users without querying Keycloak.
+type snapshotCache struct {
+	mu        gosync.RWMutex
+	snapshots map[types.NamespacedName]*snapshot
+}
+
+// snapshot holds the attribute values of a single AttributeSync keyed by username.
+type snapshot struct {
+	mu     gosync.RWMutex
+	values map[string]string
+
+	targetLabel, targetAnnotation string
+}
+
+var _ sync.ValueRecorder = &snapshot{}
+
+func newSnapshotCache() *snapshotCache {
+	return &snapshotCache{snapshots: map[types.NamespacedName]*snapshot{}}
+}
+
+// reset replaces the snapshot of the given AttributeSync with an empty one.
+// Used for full synchronizations, which record the values of all users.
+func (c *snapshotCache) reset(key types.NamespacedName, targetLabel, targetAnnotation string) *snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := &snapshot{values: map[string]string{}, targetLabel: targetLabel, targetAnnotation: targetAnnotation}
+	c.snapshots[key] = s
+	return s
+}
+
+// get returns the snapshot of the given AttributeSync. A new snapshot is created if none exists or the targets changed.
+func (c *snapshotCache) get(key types.NamespacedName, targetLabel, targetAnnotation string) *snapshot {
+	c.mu.RLock()
+	s, ok := c.snapshots[key]
+	c.mu.RUnlock()
+	if ok && s.targetLabel == targetLabel && s.targetAnnotation == targetAnnotation {
+		return s
+	}
+	return c.reset(key, targetLabel, targetAnnotation)
+}
+
+func (c *snapshotCache) delete(key types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.snapshots, key)
+}
+
+// lookup returns the snapshot of the given AttributeSync if one exists.
+func (c *snapshotCache) lookup(key types.NamespacedName) (*snapshot, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s, ok := c.snapshots[key]
+	return s, ok
+}
+
+// recorded returns true if the snapshot of the given AttributeSync with the given targets holds a value for the user.
+func (c *snapshotCache) recorded(key types.NamespacedName, targetLabel, targetAnnotation, username string) bool {
+	s, ok := c.lookup(key)
+	if !ok || s.targetLabel != targetLabel || s.targetAnnotation != targetAnnotation {
+		return false
+	}
+	_, ok = s.value(username)
+	return ok
+}
+
+// conflict returns another AttributeSync syncing a different value for the user to the label or annotation of the given AttributeSync.
+func (c *snapshotCache) conflict(key types.NamespacedName, username, value string) (types.NamespacedName, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	own, ok := c.snapshots[key]
+	if !ok {
+		return types.NamespacedName{}, false
+	}
+	for other, s := range c.snapshots {
+		if other == key {
+			continue
+		}
+		sharedLabel := own.targetLabel != "" && s.targetLabel == own.targetLabel
+		sharedAnnotation := own.targetAnnotation != "" && s.targetAnnotation == own.targetAnnotation
+		if !sharedLabel && !sharedAnnotation {
+			continue
+		}
+		if v, ok := s.value(username); ok && v != value {
+			return other, true
+		}
+	}
+	return types.NamespacedName{}, false
+}
+
+// managedKeysChanged returns true if any label or annotation managed by an AttributeSync differs between the given users.
+func (c *snapshotCache) managedKeysChanged(old, new *userv1.User) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, s := range c.snapshots {
+		if s.targetLabel != "" && old.Labels[s.targetLabel] != new.Labels[s.targetLabel] {
+			return true
+		}
+		if s.targetAnnotation != "" && old.Annotations[s.targetAnnotation] != new.Annotations[s.targetAnnotation] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *snapshot) RecordValue(username, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[username] = value
+}
+
+func (s *snapshot) value(username string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.values[username]
+	return v, ok
+}
+
+// drifted returns true if the managed label or annotation of the user differs from the given value.
+func (s *snapshot) drifted(user *userv1.User, value string) bool {
+	if s.targetLabel != "" && user.Labels[s.targetLabel] != value {
+		return true
+	}
+	return s.targetAnnotation != "" && user.Annotations[s.targetAnnotation] != value
+}
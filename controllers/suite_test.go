@@ -3,6 +3,7 @@ package controllers
 import (
 	"crypto/tls"
 	"path/filepath"
+	gosync "sync"
 	"testing"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gexec"
 	userv1 "github.com/openshift/api/user/v1"
+	corev1 "k8s.io/api/core/v1"
 	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -35,6 +37,20 @@ var k8sClient client.Client
 var testEnv *envtest.Environment
 var keycloakFakeClient = &keycloak.FakeClient{Users: []*gocloak.User{}}
 
+// keycloakClientURLs counts the Keycloak clients built by the AttributeSync controller per URL
+var keycloakClientURLs = struct {
+	gosync.Mutex
+	built map[string]int
+}{built: map[string]int{}}
+
+func keycloakClientsBuilt(url string) func() int {
+	return func() int {
+		keycloakClientURLs.Lock()
+		defer keycloakClientURLs.Unlock()
+		return keycloakClientURLs.built[url]
+	}
+}
+
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
 
@@ -70,15 +86,37 @@ var _ = BeforeSuite(func() {
 	Expect(k8sClient).NotTo(BeNil())
 
 	k8sManager, err := ctrl.NewManager(cfg, ctrl.Options{
-		Scheme: scheme.Scheme,
+		Scheme:                scheme.Scheme,
+		ClientDisableCacheFor: []client.Object{&corev1.ConfigMap{}},
 	})
 	Expect(err).ToNot(HaveOccurred())
 
 	err = (&AttributeSyncReconciler{
+		Client:    k8sManager.GetClient(),
+		APIReader: k8sManager.GetAPIReader(),
+		Scheme:    k8sManager.GetScheme(),
+
+		KeycloakClientBuilder: func(url, _, _, _ string, _ *tls.Config, _ keycloak.TransportOptions) keycloak.Client {
+			keycloakClientURLs.Lock()
+			defer keycloakClientURLs.Unlock()
+			keycloakClientURLs.built[url]++
+			return keycloakFakeClient
+		},
+
+		Recorder:                 k8sManager.GetEventRecorderFor("keycloak-attribute-sync-controller"),
+		DriftEvents:              true,
+		ClusterResourceNamespace: "default",
+		UserUpdateWorkers:        4,
+	}).SetupWithManager(k8sManager)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&KeycloakConnectionReconciler{
 		Client: k8sManager.GetClient(),
 		Scheme: k8sManager.GetScheme(),
 
-		KeycloakClientBuilder: func(string, string, string, string, *tls.Config) keycloak.Client { return keycloakFakeClient },
+		KeycloakClientBuilder: func(string, string, string, string, *tls.Config, keycloak.TransportOptions) keycloak.Client {
+			return keycloakFakeClient
+		},
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
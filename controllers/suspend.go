@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+)
+
+// setSuspended sets the Suspended condition of a suspended instance.
+// The status is only updated if the condition changed, other conditions still describe the last synchronization.
+func (r *AttributeSyncReconciler) setSuspended(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject) error {
+	cond := meta.FindStatusCondition(instance.GetStatus().Conditions, keycloakv1alpha1.ConditionSuspended)
+	if cond != nil && cond.Status == metav1.ConditionTrue && cond.ObservedGeneration == instance.GetGeneration() {
+		return nil
+	}
+	meta.SetStatusCondition(&instance.GetStatus().Conditions, metav1.Condition{
+		Type:               keycloakv1alpha1.ConditionSuspended,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: instance.GetGeneration(),
+		Reason:             keycloakv1alpha1.ReasonSuspended,
+		Message:            "Synchronization is suspended",
+	})
+	return r.Client.Status().Update(ctx, instance)
+}
+
+// syncNowRequested returns the value of the sync-now annotation and whether it requests a synchronization which was not run yet.
+func syncNowRequested(instance keycloakv1alpha1.AttributeSyncObject) (string, bool) {
+	requested, ok := instance.GetAnnotations()[keycloakv1alpha1.SyncNowAnnotation]
+	return requested, ok && requested != instance.GetStatus().LastHandledSyncNow
+}
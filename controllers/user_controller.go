@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	userv1 "github.com/openshift/api/user/v1"
+	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/sync"
+)
+
+// reconcileUser syncs a single OpenShift user using all AttributeSync and ClusterAttributeSync objects.
+// Users without a value in the snapshot of an instance, like users created after the controller started, are looked up in Keycloak,
+// which allows labeling new users on their first login instead of waiting for the next scheduled synchronization.
+// The decision is made per instance, so an instance failing to sync a new user retries the lookup even if another instance succeeded.
+// Otherwise, labels and annotations changed by hand are restored from the snapshot of the last synchronization.
+func (r *AttributeSyncReconciler) reconcileUser(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+
+	user := &userv1.User{}
+	if err := r.Client.Get(ctx, req.NamespacedName, user); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	instances, err := r.listInstances(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	errs := []error{}
+	for _, instance := range instances {
+		if !instance.GetDeletionTimestamp().IsZero() || instance.GetSpec().DryRun || instance.GetSpec().Suspend || !r.Sharder.Owns(instance) {
+			continue
+		}
+		ctx := log.IntoContext(ctx, l.WithValues("attributesync", describe(instance)))
+
+		spec := instance.GetSpec()
+		var err error
+		if r.snapshots.recorded(client.ObjectKeyFromObject(instance), spec.TargetLabel, spec.TargetAnnotation, user.Name) {
+			err = r.correctDrift(ctx, instance, user)
+		} else {
+			err = r.syncNewUser(ctx, instance, user)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", describe(instance), err))
+		}
+	}
+
+	return ctrl.Result{}, utilerrors.NewAggregate(errs)
+}
+
+func (r *AttributeSyncReconciler) syncNewUser(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject, user *userv1.User) error {
+	spec := instance.GetSpec()
+	log.FromContext(ctx).Info("Syncing new user")
+
+	kc, err := r.KeycloakClient(ctx, instance)
+	if err != nil {
+		return err
+	}
+
+	syncer := sync.UserSyncer{
+		KeycloakClient: kc,
+		K8sClient:      r.Client,
+		APIReader:      r.APIReader,
+		UpdateLimiter:  r.UserUpdateLimiter,
+		Owner:          client.ObjectKeyFromObject(instance),
+		EventRecorder:  r.Recorder,
+		ValueRecorder:  r.snapshots.get(client.ObjectKeyFromObject(instance), spec.TargetLabel, spec.TargetAnnotation),
+	}
+	return syncer.SyncUser(ctx, spec.Realm, user.Name, spec.Attribute, spec.TargetLabel, spec.TargetAnnotation)
+}
+
+// correctDrift restores the value of the last synchronization if the managed label or annotation of the user was changed.
+func (r *AttributeSyncReconciler) correctDrift(ctx context.Context, instance keycloakv1alpha1.AttributeSyncObject, user *userv1.User) error {
+	spec := instance.GetSpec()
+	snap, ok := r.snapshots.lookup(client.ObjectKeyFromObject(instance))
+	if !ok || snap.targetLabel != spec.TargetLabel || snap.targetAnnotation != spec.TargetAnnotation {
+		return nil
+	}
+	value, ok := snap.value(user.Name)
+	if !ok || !snap.drifted(user, value) {
+		return nil
+	}
+	// Restoring a key shared with another instance expecting a different value would trigger its restore in turn
+	if other, conflict := r.snapshots.conflict(client.ObjectKeyFromObject(instance), user.Name, value); conflict {
+		log.FromContext(ctx).Info("Not correcting drift on user, value conflicts with another sync config", "other", describeKey(other))
+		r.recordEvent(instance, corev1.EventTypeWarning, "DriftConflict",
+			"Not restoring value %q on user %s, %s syncs a different value to the same label or annotation", value, user.Name, describeKey(other))
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Correcting drift on user")
+	syncer := sync.UserSyncer{
+		K8sClient:     r.Client,
+		APIReader:     r.APIReader,
+		UpdateLimiter: r.UserUpdateLimiter,
+		Owner:         client.ObjectKeyFromObject(instance),
+		EventRecorder: r.Recorder,
+	}
+	if _, err := syncer.ApplyValue(ctx, user.Name, value, spec.TargetLabel, spec.TargetAnnotation); err != nil {
+		return err
+	}
+
+	if r.DriftEvents && r.Recorder != nil {
+		r.Recorder.Eventf(user, corev1.EventTypeWarning, "DriftCorrected",
+			"Restored value %q managed by %s", value, describe(instance))
+	}
+	return nil
+}
+
+// userPredicate accepts users created after the controller started which were never synced and updates changing a label or annotation
+// managed by an AttributeSync. The create events of all existing users, emitted when the controller starts, are ignored,
+// those users are synced by the next full synchronization. Creation timestamps have second precision, so users created
+// within the second the controller started are accepted as well.
+func (r *AttributeSyncReconciler) userPredicate(started time.Time) predicate.Predicate {
+	started = started.Truncate(time.Second)
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			_, synced := e.Object.GetAnnotations()[sync.SyncTimeAnnotation]
+			return !synced && !e.Object.GetCreationTimestamp().Time.Before(started)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldUser, ok := e.ObjectOld.(*userv1.User)
+			if !ok {
+				return false
+			}
+			newUser, ok := e.ObjectNew.(*userv1.User)
+			if !ok {
+				return false
+			}
+			return r.snapshots.managedKeysChanged(oldUser, newUser)
+		},
+		DeleteFunc: func(event.DeleteEvent) bool {
+			return false
+		},
+		GenericFunc: func(event.GenericEvent) bool {
+			return false
+		},
+	}
+}
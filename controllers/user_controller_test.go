@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"github.com/Nerzal/gocloak/v9"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	userv1 "github.com/openshift/api/user/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	keycloakv1alpha1 "github.com/appuio/keycloak-attribute-sync-controller/api/v1alpha1"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/keycloak"
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/sync"
+)
+
+var _ = Describe("User predicate", func() {
+	started := time.Date(2021, 6, 1, 12, 0, 0, 500, time.UTC)
+	pred := (&AttributeSyncReconciler{}).userPredicate(started)
+	newUser := func(created time.Time, annotations map[string]string) *userv1.User {
+		return &userv1.User{ObjectMeta: metav1.ObjectMeta{
+			Name:              "new-user",
+			CreationTimestamp: metav1.NewTime(created),
+			Annotations:       annotations,
+		}}
+	}
+
+	It("It should accept users created after the controller started", func() {
+		Expect(pred.Create(event.CreateEvent{Object: newUser(started.Add(time.Minute), nil)})).Should(BeTrue())
+		Expect(pred.Create(event.CreateEvent{Object: newUser(started.Truncate(time.Second), nil)})).Should(BeTrue())
+	})
+
+	It("It should ignore existing users replayed on start", func() {
+		Expect(pred.Create(event.CreateEvent{Object: newUser(started.Add(-time.Hour), nil)})).Should(BeFalse())
+	})
+
+	It("It should ignore users which were already synced", func() {
+		synced := map[string]string{sync.SyncTimeAnnotation: "2021-06-01T12:00:00Z"}
+		Expect(pred.Create(event.CreateEvent{Object: newUser(started.Add(time.Minute), synced)})).Should(BeFalse())
+	})
+})
+
+var _ = Describe("User reconciliation", func() {
+	const (
+		attribute = "example.com/organization"
+		labelA    = "example.com/organization-a"
+		labelB    = "example.com/organization-b"
+	)
+
+	It("It should sync a new user again for the sync config which failed", func() {
+		ctx := context.Background()
+
+		s := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(s)).Should(Succeed())
+		Expect(keycloakv1alpha1.AddToScheme(s)).Should(Succeed())
+		Expect(userv1.AddToScheme(s)).Should(Succeed())
+
+		newSync := func(name, label string) *keycloakv1alpha1.AttributeSync {
+			return &keycloakv1alpha1.AttributeSync{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec: keycloakv1alpha1.AttributeSyncSpec{
+					URL:               "https://keycloak.example.com",
+					Attribute:         attribute,
+					TargetLabel:       label,
+					CredentialsSecret: corev1.SecretReference{Name: name},
+				},
+			}
+		}
+		newSecret := func(name string) *corev1.Secret {
+			return &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Data:       map[string][]byte{"username": []byte("user"), "password": []byte("pw")},
+			}
+		}
+		c := fake.NewClientBuilder().WithScheme(s).WithObjects(
+			newSync("sync-a", labelA),
+			newSync("sync-b", labelB),
+			newSecret("sync-a"),
+			&userv1.User{ObjectMeta: metav1.ObjectMeta{Name: "new-user"}},
+		).Build()
+		kc := &keycloak.FakeClient{Users: []*gocloak.User{keycloak.UserWithAttribute("new-user", attribute, "IgniteCyber")}}
+		r := &AttributeSyncReconciler{
+			Client: c,
+			Scheme: s,
+			KeycloakClientBuilder: func(string, string, string, string, *tls.Config, keycloak.TransportOptions) keycloak.Client {
+				return kc
+			},
+			snapshots: newSnapshotCache(),
+		}
+		req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "new-user"}}
+		labels := func() map[string]string {
+			user := &userv1.User{}
+			Expect(c.Get(ctx, req.NamespacedName, user)).Should(Succeed())
+			return user.Labels
+		}
+
+		By("By failing to sync the user for the sync config without credentials")
+		_, err := r.reconcileUser(ctx, req)
+		Expect(err).Should(HaveOccurred())
+		Expect(labels()).Should(HaveKeyWithValue(labelA, "IgniteCyber"))
+		Expect(labels()).ShouldNot(HaveKey(labelB))
+
+		By("By retrying once the credentials exist")
+		Expect(c.Create(ctx, newSecret("sync-b"))).Should(Succeed())
+		_, err = r.reconcileUser(ctx, req)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(labels()).Should(HaveKeyWithValue(labelB, "IgniteCyber"))
+	})
+})
+
+var _ = Describe("Drift correction", func() {
+	const label = "example.com/organization"
+
+	It("It should not restore a value conflicting with another sync config", func() {
+		ctx := context.Background()
+
+		s := runtime.NewScheme()
+		Expect(userv1.AddToScheme(s)).Should(Succeed())
+		user := &userv1.User{ObjectMeta: metav1.ObjectMeta{Name: "shared-user", Labels: map[string]string{label: "IgniteCyber"}}}
+		c := fake.NewClientBuilder().WithScheme(s).WithObjects(user).Build()
+		recorder := record.NewFakeRecorder(10)
+		r := &AttributeSyncReconciler{Client: c, Recorder: recorder, snapshots: newSnapshotCache()}
+
+		newSync := func(name string) *keycloakv1alpha1.AttributeSync {
+			return &keycloakv1alpha1.AttributeSync{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+				Spec:       keycloakv1alpha1.AttributeSyncSpec{TargetLabel: label},
+			}
+		}
+		syncA, syncB := newSync("sync-a"), newSync("sync-b")
+		r.snapshots.get(client.ObjectKeyFromObject(syncA), label, "").RecordValue("shared-user", "IgniteCyber")
+		r.snapshots.get(client.ObjectKeyFromObject(syncB), label, "").RecordValue("shared-user", "Blockchain")
+
+		By("By correcting the drift for the sync config with the other value")
+		Expect(r.correctDrift(ctx, syncB, user)).Should(Succeed())
+
+		updated := &userv1.User{}
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(user), updated)).Should(Succeed())
+		Expect(updated.Labels).Should(HaveKeyWithValue(label, "IgniteCyber"))
+		Expect(recorder.Events).Should(Receive(ContainSubstring("DriftConflict")))
+	})
+})
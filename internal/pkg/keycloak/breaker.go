@@ -0,0 +1,78 @@
+package keycloak
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// breakerThreshold is the number of consecutive transient failures opening the circuit
+	breakerThreshold = 5
+	// breakerOpenDuration is the time no requests are sent to Keycloak once the circuit is open
+	breakerOpenDuration = 30 * time.Second
+)
+
+// breakers holds the circuit breaker of every Keycloak URL. They are shared by all clients,
+// so an outage detected by one AttributeSync stops all others from hammering the same Keycloak.
+var breakers = struct {
+	sync.Mutex
+	m map[string]*circuitBreaker
+}{m: map[string]*circuitBreaker{}}
+
+func breakerFor(url string) *circuitBreaker {
+	breakers.Lock()
+	defer breakers.Unlock()
+	b, ok := breakers.m[url]
+	if !ok {
+		b = &circuitBreaker{url: url}
+		breakers.m[url] = b
+	}
+	return b
+}
+
+// circuitBreaker rejects requests after breakerThreshold consecutive transient failures.
+// Once breakerOpenDuration has passed, a single request is let through to probe whether Keycloak recovered.
+type circuitBreaker struct {
+	mu sync.Mutex
+
+	url       string
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow returns an UnavailableError if no request must be sent to Keycloak.
+func (b *circuitBreaker) allow(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < breakerThreshold {
+		return nil
+	}
+	if now.Before(b.openUntil) {
+		return &UnavailableError{URL: b.url, RetryAfter: b.openUntil.Sub(now)}
+	}
+	if b.probing {
+		return &UnavailableError{URL: b.url, RetryAfter: breakerOpenDuration}
+	}
+	b.probing = true
+	return nil
+}
+
+// record updates the breaker with the result of a request.
+func (b *circuitBreaker) record(err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err == nil || !IsTransient(err) {
+		b.failures = 0
+		circuitOpen.WithLabelValues(b.url).Set(0)
+		return
+	}
+	b.failures++
+	if b.failures >= breakerThreshold {
+		b.openUntil = now.Add(breakerOpenDuration)
+		circuitOpen.WithLabelValues(b.url).Set(1)
+	}
+}
@@ -0,0 +1,86 @@
+package keycloak
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	transient := &gocloak.APIError{Code: 503}
+	permanent := &gocloak.APIError{Code: 401}
+
+	type step struct {
+		// at is the time of the step relative to the start
+		at time.Duration
+		// result is recorded after the request, if it was allowed
+		result error
+		// allowed is whether the request is expected to be sent
+		allowed bool
+		// inflight requests don't record a result before the next step
+		inflight bool
+	}
+	failures := func(n int, at time.Duration) []step {
+		steps := make([]step, n)
+		for i := range steps {
+			steps[i] = step{at: at, result: transient, allowed: true}
+		}
+		return steps
+	}
+	tests := map[string][]step{
+		"stays closed below threshold": append(failures(breakerThreshold-1, 0),
+			step{at: time.Second, result: nil, allowed: true},
+		),
+		"opens after threshold": append(failures(breakerThreshold, 0),
+			step{at: time.Second, allowed: false},
+			step{at: breakerOpenDuration - time.Second, allowed: false},
+		),
+		"ignores permanent errors": append(failures(breakerThreshold-1, 0),
+			step{at: 0, result: permanent, allowed: true},
+			step{at: 0, result: transient, allowed: true},
+			step{at: 0, allowed: true},
+		),
+		"resets on success": append(append(failures(breakerThreshold-1, 0),
+			step{at: 0, result: nil, allowed: true}),
+			append(failures(breakerThreshold-1, 0), step{at: 0, allowed: true})...,
+		),
+		"probes once after open duration": append(failures(breakerThreshold, 0),
+			step{at: breakerOpenDuration, allowed: true, inflight: true},
+			step{at: breakerOpenDuration, allowed: false},
+		),
+		"closes after successful probe": append(failures(breakerThreshold, 0),
+			step{at: breakerOpenDuration, result: nil, allowed: true},
+			step{at: breakerOpenDuration, allowed: true},
+		),
+		"opens again after failed probe": append(failures(breakerThreshold, 0),
+			step{at: breakerOpenDuration, result: transient, allowed: true},
+			step{at: breakerOpenDuration + time.Second, allowed: false},
+			step{at: 2 * breakerOpenDuration, allowed: true},
+		),
+	}
+	for name, steps := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := &circuitBreaker{url: "https://keycloak.example.com"}
+			for i, s := range steps {
+				now := start.Add(s.at)
+				err := b.allow(now)
+				if !s.allowed {
+					var unavailable *UnavailableError
+					assert.True(t, errors.As(err, &unavailable), "step %d: expected request to be rejected", i)
+					continue
+				}
+				if !assert.NoError(t, err, "step %d", i) {
+					return
+				}
+				// The last step only checks whether the request is allowed
+				if !s.inflight && i < len(steps)-1 {
+					b.record(s.result, now)
+				}
+			}
+		})
+	}
+}
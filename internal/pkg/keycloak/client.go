@@ -4,41 +4,310 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Nerzal/gocloak/v9"
 )
 
 type Client interface {
 	GetUsers(ctx context.Context, realm string, params gocloak.GetUsersParams) ([]*gocloak.User, error)
+	GetUserByID(ctx context.Context, realm, userID string) (*gocloak.User, error)
+	GetAdminEvents(ctx context.Context, realm string, params GetAdminEventsParams) ([]*AdminEvent, error)
+	UpdateUser(ctx context.Context, realm string, user gocloak.User) error
+	// Ping authenticates to Keycloak to verify the connection and credentials
+	Ping(ctx context.Context) error
+	// Session calls f with a client which logs in once and reuses the access token for all requests until it expires,
+	// instead of logging in for every request. The session is logged out once f returns.
+	Session(ctx context.Context, f func(c Client) error) error
+}
+
+// AdminEvent is an entry of the admin events log of a Keycloak realm.
+// gocloak does not support admin events, so only the fields we need are mapped.
+type AdminEvent struct {
+	Time          int64  `json:"time"`
+	RealmID       string `json:"realmId"`
+	OperationType string `json:"operationType"`
+	ResourceType  string `json:"resourceType"`
+	ResourcePath  string `json:"resourcePath"`
+}
+
+// UserID returns the ID of the user the event is about, or an empty string if the event does not concern a user.
+func (e *AdminEvent) UserID() string {
+	if e.ResourceType != "USER" {
+		return ""
+	}
+	// The path is `users/<id>`, or `users/<id>/<subresource>` for changes like a reset password
+	parts := strings.Split(strings.TrimPrefix(e.ResourcePath, "/"), "/")
+	if len(parts) < 2 || parts[0] != "users" {
+		return ""
+	}
+	return parts[1]
+}
+
+// GetAdminEventsParams filters the admin events returned by GetAdminEvents
+type GetAdminEventsParams struct {
+	OperationTypes []string
+	ResourceTypes  []string
+	// DateFrom is the first day to return events for, formatted as `yyyy-MM-dd`
+	DateFrom string
+	First    int
+	Max      int
+}
+
+// TransportOptions configures the HTTP client used to connect to Keycloak
+type TransportOptions struct {
+	// Timeout is the timeout of a single request, zero means no timeout
+	Timeout time.Duration
+	// ProxyURL is the proxy used for all requests. If empty, the proxy is read from the environment.
+	ProxyURL string
+	// RetryCount is the number of times a request failing with a transient network error, a 5xx or a 429 response is retried
+	RetryCount int
+	// Headers are added to all requests
+	Headers map[string]string
 }
 
 type gocloakClient struct {
 	client gocloak.GoCloak
 
+	baseUrl            string
 	loginRealm         string
 	username, password string
+
+	// session, if not nil, keeps the access token shared by all requests of a Session
+	session *session
+}
+
+// session holds the access token of a Session
+type session struct {
+	mu      sync.Mutex
+	token   *gocloak.JWT
+	expires time.Time
 }
 
-func NewClient(baseUrl, loginRealm, username, password string, tlsConfig *tls.Config) Client {
+// tokenExpiryMargin is the time before its expiry after which an access token of a session is no longer used
+const tokenExpiryMargin = 10 * time.Second
+
+func NewClient(baseUrl, loginRealm, username, password string, tlsConfig *tls.Config, transport TransportOptions) Client {
 	client := gocloak.NewClient(baseUrl)
-	client.SetRestyClient(client.RestyClient().SetTLSClientConfig(tlsConfig))
+	baseUrl = strings.TrimRight(baseUrl, "/")
+	restyClient := client.RestyClient().
+		SetTLSClientConfig(tlsConfig).
+		SetTimeout(transport.Timeout).
+		SetRetryCount(transport.RetryCount).
+		SetRetryWaitTime(retryWaitTime).
+		SetRetryMaxWaitTime(retryMaxWaitTime).
+		SetRetryAfter(retryAfter).
+		AddRetryCondition(retryCondition).
+		SetHeaders(transport.Headers).
+		OnBeforeRequest(rateLimit(baseUrl)).
+		OnError(recordTransportError)
+	if transport.ProxyURL != "" {
+		restyClient.SetProxy(transport.ProxyURL)
+	}
+	client.SetRestyClient(restyClient)
 
 	return &gocloakClient{
 		client: client,
 
+		baseUrl:    baseUrl,
 		loginRealm: loginRealm,
 		username:   username,
 		password:   password,
 	}
 }
 
+// usersPageSize is the number of users fetched per request if all users are requested with a negative Max,
+// so the timeout of a single request doesn't limit the size of the realm.
+const usersPageSize = 500
+
+// GetUsers returns the users matching the params. A negative Max fetches all users in pages of usersPageSize within a single session.
 func (g *gocloakClient) GetUsers(ctx context.Context, realm string, params gocloak.GetUsersParams) ([]*gocloak.User, error) {
-	token, err := g.client.LoginAdmin(ctx, g.username, g.password, g.loginRealm)
+	if params.Max == nil || *params.Max >= 0 {
+		return g.getUsers(ctx, realm, params)
+	}
+	if g.session == nil {
+		var users []*gocloak.User
+		err := g.Session(ctx, func(c Client) (err error) {
+			users, err = c.GetUsers(ctx, realm, params)
+			return err
+		})
+		return users, err
+	}
+
+	var users []*gocloak.User
+	params.Max = gocloak.IntP(usersPageSize)
+	for first := 0; ; first += usersPageSize {
+		params.First = gocloak.IntP(first)
+		page, err := g.getUsers(ctx, realm, params)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, page...)
+		if len(page) < usersPageSize {
+			return users, nil
+		}
+	}
+}
+
+func (g *gocloakClient) getUsers(ctx context.Context, realm string, params gocloak.GetUsersParams) ([]*gocloak.User, error) {
+	var users []*gocloak.User
+	err := g.withToken(ctx, func(ctx context.Context, token string) error {
+		return observe("users", func() (err error) {
+			users, err = g.client.GetUsers(ctx, token, realm, params)
+			return err
+		})
+	})
+	return users, err
+}
+
+func (g *gocloakClient) GetUserByID(ctx context.Context, realm, userID string) (*gocloak.User, error) {
+	var user *gocloak.User
+	err := g.withToken(ctx, func(ctx context.Context, token string) error {
+		return observe("user", func() (err error) {
+			user, err = g.client.GetUserByID(ctx, token, realm, userID)
+			return err
+		})
+	})
+	return user, err
+}
+
+func (g *gocloakClient) UpdateUser(ctx context.Context, realm string, user gocloak.User) error {
+	return g.withToken(ctx, func(ctx context.Context, token string) error {
+		return observe("update-user", func() error {
+			return g.client.UpdateUser(ctx, token, realm, user)
+		})
+	})
+}
+
+func (g *gocloakClient) Ping(ctx context.Context) error {
+	return g.withToken(ctx, func(context.Context, string) error { return nil })
+}
+
+func (g *gocloakClient) Session(ctx context.Context, f func(c Client) error) error {
+	s := *g
+	s.session = &session{}
+	defer s.logoutSession(ctx)
+	return f(&s)
+}
+
+func (g *gocloakClient) GetAdminEvents(ctx context.Context, realm string, params GetAdminEventsParams) ([]*AdminEvent, error) {
+	query := url.Values{}
+	for _, t := range params.OperationTypes {
+		query.Add("operationTypes", t)
+	}
+	for _, t := range params.ResourceTypes {
+		query.Add("resourceTypes", t)
+	}
+	if params.DateFrom != "" {
+		query.Set("dateFrom", params.DateFrom)
+	}
+	query.Set("first", strconv.Itoa(params.First))
+	if params.Max > 0 {
+		query.Set("max", strconv.Itoa(params.Max))
+	}
+
+	var events []*AdminEvent
+	err := g.withToken(ctx, func(ctx context.Context, token string) error {
+		return observe("admin-events", func() error {
+			return g.getAdminEvents(ctx, token, realm, query, &events)
+		})
+	})
+	return events, err
+}
+
+func (g *gocloakClient) getAdminEvents(ctx context.Context, token, realm string, query url.Values, events *[]*AdminEvent) error {
+	resp, err := g.client.RestyClient().R().
+		SetContext(ctx).
+		SetAuthToken(token).
+		SetQueryParamsFromValues(query).
+		SetResult(events).
+		Get(fmt.Sprintf("%s/auth/admin/realms/%s/admin-events", g.baseUrl, url.PathEscape(realm)))
+	if err != nil {
+		return fmt.Errorf("could not get admin events: %w", err)
+	}
+	if resp.IsError() {
+		return &gocloak.APIError{Code: resp.StatusCode(), Message: fmt.Sprintf("could not get admin events: %s", resp.Status())}
+	}
+	return nil
+}
+
+// withToken logs in to the admin API, unless the access token of the session can be reused, and calls f with the access token and a context recording transport errors,
+// which are added to the returned error. No request is sent while the circuit breaker of the Keycloak URL is open.
+func (g *gocloakClient) withToken(ctx context.Context, f func(ctx context.Context, token string) error) error {
+	breaker := breakerFor(g.baseUrl)
+	if err := breaker.allow(time.Now()); err != nil {
+		return err
+	}
+	ctx, rec := withTransportErrorRecorder(ctx)
+	err := rec.wrap(g.login(ctx, f))
+	breaker.record(err, time.Now())
+	return err
+}
+
+func (g *gocloakClient) login(ctx context.Context, f func(ctx context.Context, token string) error) error {
+	if g.session != nil {
+		token, err := g.sessionToken(ctx)
+		if err != nil {
+			return err
+		}
+		return f(ctx, token)
+	}
+
+	token, err := g.loginAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	defer g.logout(ctx, token)
+
+	return f(ctx, token.AccessToken)
+}
+
+func (g *gocloakClient) loginAdmin(ctx context.Context) (*gocloak.JWT, error) {
+	var token *gocloak.JWT
+	err := observe("login", func() (err error) {
+		token, err = g.client.LoginAdmin(ctx, g.username, g.password, g.loginRealm)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed binding to keycloak: %w", err)
+		return nil, &LoginError{Err: err}
 	}
+	return token, nil
+}
+
+func (g *gocloakClient) logout(ctx context.Context, token *gocloak.JWT) {
 	// `admin-cli` is the magic client used when authenticating to the admin API
-	defer g.client.LogoutPublicClient(ctx, "admin-cli", g.loginRealm, token.AccessToken, token.RefreshToken)
+	g.client.LogoutPublicClient(ctx, "admin-cli", g.loginRealm, token.AccessToken, token.RefreshToken)
+}
+
+// sessionToken returns the access token of the session, logging in again if it is about to expire.
+func (g *gocloakClient) sessionToken(ctx context.Context) (string, error) {
+	g.session.mu.Lock()
+	defer g.session.mu.Unlock()
+	if g.session.token != nil && time.Now().Before(g.session.expires) {
+		return g.session.token.AccessToken, nil
+	}
+	if g.session.token != nil {
+		g.logout(ctx, g.session.token)
+		g.session.token = nil
+	}
+	token, err := g.loginAdmin(ctx)
+	if err != nil {
+		return "", err
+	}
+	g.session.token = token
+	g.session.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return token.AccessToken, nil
+}
 
-	return g.client.GetUsers(ctx, token.AccessToken, realm, params)
+func (g *gocloakClient) logoutSession(ctx context.Context) {
+	g.session.mu.Lock()
+	defer g.session.mu.Unlock()
+	if g.session.token != nil {
+		g.logout(ctx, g.session.token)
+		g.session.token = nil
+	}
 }
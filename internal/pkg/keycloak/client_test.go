@@ -0,0 +1,114 @@
+package keycloak
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminEvent_UserID(t *testing.T) {
+	tests := map[string]struct {
+		resourceType string
+		resourcePath string
+		want         string
+	}{
+		"user":              {resourceType: "USER", resourcePath: "users/0b8a7c1e", want: "0b8a7c1e"},
+		"leading slash":     {resourceType: "USER", resourcePath: "/users/0b8a7c1e", want: "0b8a7c1e"},
+		"subresource":       {resourceType: "USER", resourcePath: "users/0b8a7c1e/reset-password", want: "0b8a7c1e"},
+		"other resource":    {resourceType: "GROUP", resourcePath: "groups/0b8a7c1e", want: ""},
+		"other path":        {resourceType: "USER", resourcePath: "groups/0b8a7c1e", want: ""},
+		"missing id":        {resourceType: "USER", resourcePath: "users", want: ""},
+		"empty path":        {resourceType: "USER", resourcePath: "", want: ""},
+		"role mapping path": {resourceType: "REALM_ROLE_MAPPING", resourcePath: "users/0b8a7c1e/role-mappings/realm", want: ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			event := &AdminEvent{ResourceType: tt.resourceType, ResourcePath: tt.resourcePath}
+			assert.Equal(t, tt.want, event.UserID())
+		})
+	}
+}
+
+func TestGocloakClient_Session(t *testing.T) {
+	var logins, logouts int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/realms/master/protocol/openid-connect/token", func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&logins, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"token","refresh_token":"refresh","expires_in":60}`)
+	})
+	mux.HandleFunc("/auth/realms/master/protocol/openid-connect/logout", func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&logouts, 1)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/auth/admin/realms/realm/users/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":%q}`, strings.TrimPrefix(r.URL.Path, "/auth/admin/realms/realm/users/"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := NewClient(server.URL, "master", "admin", "password", nil, TransportOptions{})
+	err := c.Session(context.Background(), func(c Client) error {
+		for _, id := range []string{"alice", "bob", "carol"} {
+			user, err := c.GetUserByID(context.Background(), "realm", id)
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, id, gocloak.PString(user.ID))
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&logins), "the token is reused within the session")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&logouts), "the session is logged out at the end")
+}
+
+func TestGocloakClient_GetAllUsers(t *testing.T) {
+	const total = 2*usersPageSize + 7
+	var logins, requests int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/realms/master/protocol/openid-connect/token", func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&logins, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"token","refresh_token":"refresh","expires_in":60}`)
+	})
+	mux.HandleFunc("/auth/realms/master/protocol/openid-connect/logout", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/auth/admin/realms/realm/users", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		first, _ := strconv.Atoi(r.URL.Query().Get("first"))
+		max, _ := strconv.Atoi(r.URL.Query().Get("max"))
+		assert.Equal(t, usersPageSize, max, "every page is limited")
+		users := []string{}
+		for i := first; i < total && i < first+max; i++ {
+			users = append(users, fmt.Sprintf(`{"username":"user-%04d"}`, i))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, "[%s]", strings.Join(users, ","))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := NewClient(server.URL, "master", "admin", "password", nil, TransportOptions{})
+	users, err := c.GetUsers(context.Background(), "realm", gocloak.GetUsersParams{Max: gocloak.IntP(-1)})
+
+	require.NoError(t, err)
+	require.Len(t, users, total)
+	for i, user := range users {
+		assert.Equal(t, fmt.Sprintf("user-%04d", i), gocloak.PString(user.Username))
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&logins), "all pages are fetched with a single login")
+}
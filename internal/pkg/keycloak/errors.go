@@ -0,0 +1,144 @@
+package keycloak
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v9"
+	"github.com/go-resty/resty/v2"
+)
+
+// LoginError is returned if authenticating to the Keycloak admin API failed
+type LoginError struct {
+	Err error
+}
+
+func (e *LoginError) Error() string {
+	return "failed binding to keycloak: " + e.Err.Error()
+}
+
+func (e *LoginError) Unwrap() error {
+	return e.Err
+}
+
+// IsTLSError returns true if the error was caused by a failed TLS handshake with Keycloak.
+func IsTLSError(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalid          x509.CertificateInvalidError
+		recordHeader     tls.RecordHeaderError
+		alert            tls.AlertError
+	)
+	return errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) || errors.As(err, &recordHeader) ||
+		errors.As(err, &alert)
+}
+
+// IsNotFound returns true if Keycloak responded with 404 Not Found, also if the error was wrapped by the client.
+func IsNotFound(err error) bool {
+	var apiErr *gocloak.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// transportError adds the error of the HTTP transport to an error returned by gocloak,
+// which only keeps the message of transport errors. Both can be matched with errors.As.
+type transportError struct {
+	err   error
+	cause error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() []error {
+	return []error{e.err, e.cause}
+}
+
+type transportErrorKey struct{}
+
+// transportErrorRecorder keeps the last transport error of the requests sent with its context
+type transportErrorRecorder struct {
+	mu  sync.Mutex
+	err error
+}
+
+// withTransportErrorRecorder returns a context recording the transport errors of all requests sent with it.
+func withTransportErrorRecorder(ctx context.Context) (context.Context, *transportErrorRecorder) {
+	rec := &transportErrorRecorder{}
+	return context.WithValue(ctx, transportErrorKey{}, rec), rec
+}
+
+// recordTransportError is a resty error hook recording the error of a failed request in the recorder of its context.
+func recordTransportError(req *resty.Request, err error) {
+	rec, ok := req.Context().Value(transportErrorKey{}).(*transportErrorRecorder)
+	if !ok {
+		return
+	}
+	var respErr *resty.ResponseError
+	if errors.As(err, &respErr) {
+		err = respErr.Err
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.err = err
+}
+
+// wrap adds the recorded transport error to err, if any.
+func (r *transportErrorRecorder) wrap(err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil || r.err == nil {
+		return err
+	}
+	return &transportError{err: err, cause: r.err}
+}
+
+// UnavailableError is returned without contacting Keycloak while the circuit breaker of its URL is open
+type UnavailableError struct {
+	URL string
+	// RetryAfter is the time until the next request to Keycloak is allowed
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("keycloak %s is unavailable, retrying in %s", e.URL, e.RetryAfter.Round(time.Second))
+}
+
+// IsTransient returns true if the error is caused by Keycloak being unavailable, such as refused or reset connections, timeouts
+// and 5xx or 429 responses, as opposed to TLS and configuration errors which need to be fixed by the user.
+func IsTransient(err error) bool {
+	var (
+		unavailable *UnavailableError
+		apiErr      *gocloak.APIError
+		opErr       *net.OpError
+	)
+	switch {
+	case errors.As(err, &unavailable):
+		return true
+	case IsTLSError(err):
+		return false
+	case errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.As(err, &apiErr):
+		// gocloak reports transport errors, including timeouts, with code 0
+		return apiErr.Code == 0 || apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= http.StatusInternalServerError
+	case errors.As(err, &opErr):
+		// Dial, read and write errors, such as refused or reset connections
+		return true
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		// The connection was closed before the response was received
+		return true
+	}
+	// *url.Error implements net.Error for any cause, so only timeouts are considered
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
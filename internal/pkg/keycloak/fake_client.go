@@ -3,13 +3,15 @@ package keycloak
 import (
 	"context"
 	"fmt"
+	"net/http"
 
 	"github.com/Nerzal/gocloak/v9"
 )
 
 type FakeClient struct {
-	Users []*gocloak.User
-	err   error
+	Users       []*gocloak.User
+	AdminEvents []*AdminEvent
+	err         error
 }
 
 var _ Client = &FakeClient{}
@@ -21,6 +23,53 @@ func (f *FakeClient) GetUsers(ctx context.Context, realm string, params gocloak.
 	return f.Users, nil
 }
 
+func (f *FakeClient) GetUserByID(ctx context.Context, realm, userID string) (*gocloak.User, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	for _, user := range f.Users {
+		if user.ID != nil && *user.ID == userID {
+			return user, nil
+		}
+	}
+	return nil, &gocloak.APIError{Code: http.StatusNotFound, Message: "404 Not Found"}
+}
+
+func (f *FakeClient) GetAdminEvents(ctx context.Context, realm string, params GetAdminEventsParams) ([]*AdminEvent, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if params.First >= len(f.AdminEvents) {
+		return []*AdminEvent{}, nil
+	}
+	events := f.AdminEvents[params.First:]
+	if params.Max > 0 && len(events) > params.Max {
+		events = events[:params.Max]
+	}
+	return events, nil
+}
+
+func (f *FakeClient) UpdateUser(ctx context.Context, realm string, user gocloak.User) error {
+	if f.err != nil {
+		return f.err
+	}
+	for i, u := range f.Users {
+		if u.ID != nil && user.ID != nil && *u.ID == *user.ID {
+			f.Users[i] = &user
+			return nil
+		}
+	}
+	return &gocloak.APIError{Code: http.StatusNotFound, Message: "404 Not Found"}
+}
+
+func (f *FakeClient) Ping(ctx context.Context) error {
+	return f.err
+}
+
+func (f *FakeClient) Session(ctx context.Context, fn func(c Client) error) error {
+	return fn(f)
+}
+
 func (f *FakeClient) FakeClientSetUserAttribute(username string, attributeKey string, attributeValues ...string) error {
 	for _, user := range f.Users {
 		if user.Username == nil || *user.Username != username {
@@ -41,5 +90,5 @@ func (f *FakeClient) FakeClientSetError(err error) {
 }
 
 func UserWithAttribute(username string, attributeKey string, attributeValues ...string) *gocloak.User {
-	return &gocloak.User{Username: &username, Attributes: &map[string][]string{attributeKey: attributeValues}}
+	return &gocloak.User{ID: &username, Username: &username, Attributes: &map[string][]string{attributeKey: attributeValues}}
 }
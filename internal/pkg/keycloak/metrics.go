@@ -0,0 +1,41 @@
+package keycloak
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "keycloak_attribute_sync_keycloak_request_duration_seconds",
+		Help:    "Latency of requests to the Keycloak API, by endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	requestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keycloak_attribute_sync_keycloak_request_errors_total",
+		Help: "Number of failed requests to the Keycloak API, by endpoint.",
+	}, []string{"endpoint"})
+
+	circuitOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "keycloak_attribute_sync_keycloak_circuit_open",
+		Help: "Whether requests to the Keycloak URL are suspended because of repeated failures.",
+	}, []string{"url"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(requestDuration, requestErrors, circuitOpen)
+}
+
+// observe calls f and records its latency and error for the given endpoint.
+func observe(endpoint string, f func() error) error {
+	start := time.Now()
+	err := f()
+	requestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	if err != nil {
+		requestErrors.WithLabelValues(endpoint).Inc()
+	}
+	return err
+}
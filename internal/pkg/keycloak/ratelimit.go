@@ -0,0 +1,52 @@
+package keycloak
+
+import (
+	"sync"
+
+	"github.com/go-resty/resty/v2"
+	"golang.org/x/time/rate"
+)
+
+// limiters holds the rate limiter of every Keycloak URL. Like the circuit breakers, they are shared by all clients,
+// so concurrent synchronizations against the same Keycloak don't add up to more requests than allowed.
+var limiters = struct {
+	sync.Mutex
+	m     map[string]*rate.Limiter
+	limit rate.Limit
+	burst int
+}{m: map[string]*rate.Limiter{}, limit: rate.Inf}
+
+// SetRateLimit limits the requests to every Keycloak URL to qps requests per second with the given burst.
+// A qps of zero or less removes the limit. Must be called before the first client is created.
+func SetRateLimit(qps float64, burst int) {
+	limiters.Lock()
+	defer limiters.Unlock()
+	limiters.limit = rate.Inf
+	if qps > 0 {
+		limiters.limit = rate.Limit(qps)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	limiters.burst = burst
+	limiters.m = map[string]*rate.Limiter{}
+}
+
+func limiterFor(url string) *rate.Limiter {
+	limiters.Lock()
+	defer limiters.Unlock()
+	l, ok := limiters.m[url]
+	if !ok {
+		l = rate.NewLimiter(limiters.limit, limiters.burst)
+		limiters.m[url] = l
+	}
+	return l
+}
+
+// rateLimit returns a request middleware waiting for the rate limiter of the Keycloak URL.
+// It runs before every attempt, so retries are limited as well.
+func rateLimit(url string) resty.RequestMiddleware {
+	return func(_ *resty.Client, r *resty.Request) error {
+		return limiterFor(url).Wait(r.Context())
+	}
+}
@@ -0,0 +1,80 @@
+package keycloak
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterFor(t *testing.T) {
+	SetRateLimit(10, 5)
+	defer SetRateLimit(0, 0)
+
+	l := limiterFor("https://keycloak.example.com")
+	assert.Same(t, l, limiterFor("https://keycloak.example.com"), "clients of the same URL share a limiter")
+	assert.NotSame(t, l, limiterFor("https://other.example.com"))
+	assert.EqualValues(t, 10, l.Limit())
+	assert.Equal(t, 5, l.Burst())
+}
+
+func TestSetRateLimit_Disabled(t *testing.T) {
+	SetRateLimit(0, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// Without a limit, requests are never delayed
+	limit := rateLimit("https://keycloak.example.com")
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, limit(nil, resty.New().R().SetContext(ctx)))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	SetRateLimit(0.001, 2)
+	defer SetRateLimit(0, 0)
+	limit := rateLimit("https://keycloak.example.com")
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, limit(nil, resty.New().R().SetContext(context.Background())), "request %d is within the burst", i)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Error(t, limit(nil, resty.New().R().SetContext(ctx)), "request exceeding the burst waits longer than the context allows")
+	assert.Less(t, time.Since(start), time.Second)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, limit(nil, resty.New().R().SetContext(cancelled)))
+}
+
+func TestNewClient_SharedRateLimit(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"token","refresh_token":"refresh","expires_in":60}`)
+	}))
+	defer server.Close()
+	// A login and a logout per ping
+	SetRateLimit(0.001, 2)
+	defer SetRateLimit(0, 0)
+
+	first := NewClient(server.URL, "master", "admin", "password", nil, TransportOptions{})
+	require.NoError(t, first.Ping(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	second := NewClient(server.URL, "master", "admin", "password", nil, TransportOptions{})
+	assert.Error(t, second.Ping(ctx), "another client of the same URL shares the exhausted limit")
+	assert.EqualValues(t, 2, atomic.LoadInt32(&requests))
+}
@@ -0,0 +1,41 @@
+package keycloak
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	// retryWaitTime is the initial backoff between retries, doubled on every attempt
+	retryWaitTime = 500 * time.Millisecond
+	// retryMaxWaitTime caps the backoff and the time waited for a `Retry-After` header
+	retryMaxWaitTime = 10 * time.Second
+)
+
+// retryCondition retries requests failing with a transient network error, a 5xx or a 429 response.
+// TLS and other permanent transport errors are not retried, see IsTransient.
+func retryCondition(resp *resty.Response, err error) bool {
+	if err != nil {
+		return IsTransient(err)
+	}
+	code := resp.StatusCode()
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// retryAfter honors the `Retry-After` header of 429 and 503 responses. It returns zero to fall back to the exponential backoff.
+func retryAfter(_ *resty.Client, resp *resty.Response) (time.Duration, error) {
+	header := resp.Header().Get("Retry-After")
+	if header == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return time.Until(t), nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,145 @@
+package keycloak
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v9"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryCondition(t *testing.T) {
+	tests := map[string]struct {
+		code int
+		err  error
+		want bool
+	}{
+		"connection refused": {err: &url.Error{Op: "Get", URL: "https://keycloak.example.com", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, want: true},
+		"connection reset":   {err: &url.Error{Op: "Get", URL: "https://keycloak.example.com", Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}, want: true},
+		"timeout":            {err: &url.Error{Op: "Get", URL: "https://keycloak.example.com", Err: context.DeadlineExceeded}, want: true},
+		"connection closed":  {err: &url.Error{Op: "Get", URL: "https://keycloak.example.com", Err: io.EOF}, want: true},
+		"tls error":          {err: &url.Error{Op: "Get", URL: "https://keycloak.example.com", Err: &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}}, want: false},
+		"tls alert":          {err: &url.Error{Op: "Get", URL: "https://keycloak.example.com", Err: &net.OpError{Op: "remote error", Err: tls.AlertError(42)}}, want: false},
+		"unsupported scheme": {err: &url.Error{Op: "Get", URL: "keycloak.example.com", Err: errors.New("unsupported protocol scheme")}, want: false},
+		"ok":                 {code: http.StatusOK, want: false},
+		"not found":          {code: http.StatusNotFound, want: false},
+		"unauthorized":       {code: http.StatusUnauthorized, want: false},
+		"too many requests":  {code: http.StatusTooManyRequests, want: true},
+		"internal error":     {code: http.StatusInternalServerError, want: true},
+		"unavailable":        {code: http.StatusServiceUnavailable, want: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp := &resty.Response{RawResponse: &http.Response{StatusCode: tt.code}}
+			assert.Equal(t, tt.want, retryCondition(resp, tt.err))
+		})
+	}
+}
+
+func TestNewClient_TLSErrorNotRetried(t *testing.T) {
+	var connections int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	// The certificate of the test server isn't trusted by the default TLS configuration
+	c := NewClient(server.URL, "master", "admin", "password", nil, TransportOptions{RetryCount: 3})
+	err := c.Ping(context.Background())
+
+	assert.True(t, IsTLSError(err), "expected a TLS error, got %v", err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&connections), "the request must not be retried")
+}
+
+func TestIsTransient(t *testing.T) {
+	tlsErr := &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"unavailable":         {err: &UnavailableError{URL: "https://keycloak.example.com"}, want: true},
+		"deadline exceeded":   {err: fmt.Errorf("request: %w", context.DeadlineExceeded), want: true},
+		"transport error":     {err: &gocloak.APIError{Code: 0, Message: "connection refused"}, want: true},
+		"too many requests":   {err: &gocloak.APIError{Code: http.StatusTooManyRequests}, want: true},
+		"server error":        {err: &gocloak.APIError{Code: http.StatusBadGateway}, want: true},
+		"not found":           {err: &gocloak.APIError{Code: http.StatusNotFound}, want: false},
+		"login failed":        {err: &LoginError{Err: &gocloak.APIError{Code: http.StatusUnauthorized}}, want: false},
+		"network error":       {err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		"timeout":             {err: &url.Error{Op: "Get", URL: "https://keycloak.example.com", Err: context.DeadlineExceeded}, want: true},
+		"unsupported scheme":  {err: &url.Error{Op: "Get", URL: "keycloak.example.com", Err: errors.New("unsupported protocol scheme")}, want: false},
+		"tls error":           {err: tlsErr, want: false},
+		"wrapped tls error":   {err: &transportError{err: &gocloak.APIError{Code: 0}, cause: tlsErr}, want: false},
+		"configuration error": {err: errors.New("invalid URL"), want: false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsTransient(tt.err))
+		})
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	notFound := &gocloak.APIError{Code: http.StatusNotFound}
+	assert.True(t, IsNotFound(notFound))
+	assert.True(t, IsNotFound(&transportError{err: notFound, cause: errors.New("cause")}), "wrapped by withToken")
+	assert.False(t, IsNotFound(&gocloak.APIError{Code: http.StatusForbidden}))
+	assert.False(t, IsNotFound(errors.New("not found")))
+}
+
+func TestIsTLSError(t *testing.T) {
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"unknown authority":   {err: &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}, want: true},
+		"hostname mismatch":   {err: x509.HostnameError{Host: "keycloak.example.com", Certificate: &x509.Certificate{}}, want: true},
+		"invalid certificate": {err: x509.CertificateInvalidError{Reason: x509.Expired, Cert: &x509.Certificate{}}, want: true},
+		"record header":       {err: fmt.Errorf("handshake: %w", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), want: true},
+		"remote alert":        {err: &net.OpError{Op: "remote error", Err: tls.AlertError(42)}, want: true},
+		"recorded by gocloak": {err: &transportError{err: &gocloak.APIError{Code: 0, Message: "x509: certificate signed by unknown authority"}, cause: x509.UnknownAuthorityError{}}, want: true},
+		"message only":        {err: &gocloak.APIError{Code: 0, Message: "x509: certificate signed by unknown authority"}, want: false},
+		"unrelated api error": {err: &gocloak.APIError{Code: http.StatusNotFound}, want: false},
+		"unrelated transport": {err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsTLSError(tt.err))
+		})
+	}
+}
+
+func TestTransportErrorRecorder(t *testing.T) {
+	ctx, rec := withTransportErrorRecorder(context.Background())
+	apiErr := &gocloak.APIError{Code: 0, Message: "tls: handshake failure"}
+	assert.Same(t, apiErr, rec.wrap(apiErr), "nothing recorded yet")
+
+	cause := tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}
+	recordTransportError(resty.New().R().SetContext(ctx), &resty.ResponseError{Err: cause})
+	err := rec.wrap(apiErr)
+
+	assert.Equal(t, apiErr.Error(), err.Error())
+	var unwrapped *gocloak.APIError
+	assert.True(t, errors.As(err, &unwrapped))
+	assert.True(t, IsTLSError(err))
+	assert.NoError(t, rec.wrap(nil))
+}
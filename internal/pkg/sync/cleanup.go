@@ -0,0 +1,73 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+
+	userv1 "github.com/openshift/api/user/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// RemoveManagedKeys removes the target label and annotation from all OpenShift users synced by the Owner and returns the number of updated users.
+// Users the Owner didn't sync keep their labels and annotations, as they were set by hand or by another AttributeSync.
+// If removeSyncTime is true, the sync time annotation is removed as well.
+func (u *UserSyncer) RemoveManagedKeys(ctx context.Context, targetLabel, targetAnnotation string, removeSyncTime bool) (int, error) {
+	l := log.FromContext(ctx)
+
+	users := &userv1.UserList{}
+	if err := u.K8sClient.List(ctx, users); err != nil {
+		return 0, fmt.Errorf("error listing users: %w", err)
+	}
+
+	removed := 0
+	var userErrs UserErrors
+	for i := range users.Items {
+		user := &users.Items[i]
+		if !removeSyncedBy(&user.ObjectMeta, u.Owner.String()) {
+			continue
+		}
+		oldLabel, hasLabel := user.Labels[targetLabel]
+		hasLabel = hasLabel && targetLabel != ""
+		oldAnnotation, hasAnnotation := user.Annotations[targetAnnotation]
+		hasAnnotation = hasAnnotation && targetAnnotation != ""
+		_, hasSyncTime := user.Annotations[SyncTimeAnnotation]
+		hasSyncTime = hasSyncTime && removeSyncTime
+
+		if hasLabel {
+			delete(user.Labels, targetLabel)
+		}
+		if hasAnnotation {
+			delete(user.Annotations, targetAnnotation)
+		}
+		if hasSyncTime {
+			delete(user.Annotations, SyncTimeAnnotation)
+		}
+		if err := u.waitForUpdate(ctx); err != nil {
+			return removed, err
+		}
+		if err := u.K8sClient.Update(ctx, user); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			l.Error(err, "unable to remove synced keys from user", "username", user.Name)
+			userErrs = append(userErrs, &UserError{Username: user.Name, Err: err})
+			continue
+		}
+		removed++
+
+		if hasAnnotation {
+			u.recordChange(user, "Annotation", targetAnnotation, oldAnnotation, "")
+		}
+		if hasLabel {
+			u.recordChange(user, "Label", targetLabel, oldLabel, "")
+		}
+	}
+
+	l.Info("Removed synced keys from users", "updated", removed, "failed", len(userErrs))
+	if len(userErrs) > 0 {
+		userErrs.sort()
+		return removed, userErrs
+	}
+	return removed, nil
+}
@@ -0,0 +1,70 @@
+package sync
+
+import (
+	"context"
+	"testing"
+
+	userv1 "github.com/openshift/api/user/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRemoveManagedKeys_OnlySyncedUsers(t *testing.T) {
+	ctx := context.Background()
+	syncer, c := newTestSyncer(t, 2, 2)
+	syncer.Owner = types.NamespacedName{Namespace: "team-a", Name: "organization"}
+	require.NoError(t, syncer.Sync(ctx, "realm", testAttribute, testLabel, ""))
+
+	// user-01 was synced by a second owner as well, user-02 only by the second owner and user-03 was labeled by hand
+	user := &userv1.User{}
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "user-01"}, user))
+	assert.Equal(t, "team-a/organization", user.Annotations[SyncedByAnnotation])
+	addSyncedBy(&user.ObjectMeta, "team-b/organization")
+	require.NoError(t, c.Update(ctx, user))
+	require.NoError(t, c.Create(ctx, &userv1.User{ObjectMeta: metav1.ObjectMeta{
+		Name:        "user-02",
+		Labels:      map[string]string{testLabel: "org-b"},
+		Annotations: map[string]string{SyncedByAnnotation: "team-b/organization"},
+	}}))
+	require.NoError(t, c.Create(ctx, &userv1.User{ObjectMeta: metav1.ObjectMeta{
+		Name:   "user-03",
+		Labels: map[string]string{testLabel: "by-hand"},
+	}}))
+
+	removed, err := syncer.RemoveManagedKeys(ctx, testLabel, "", true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	expected := map[string]struct {
+		label    string
+		syncedBy string
+	}{
+		"user-00": {},
+		"user-01": {syncedBy: "team-b/organization"},
+		"user-02": {label: "org-b", syncedBy: "team-b/organization"},
+		"user-03": {label: "by-hand"},
+	}
+	for name, e := range expected {
+		user := &userv1.User{}
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Name: name}, user))
+		assert.Equal(t, e.label, user.Labels[testLabel], name)
+		assert.Equal(t, e.syncedBy, user.Annotations[SyncedByAnnotation], name)
+	}
+}
+
+func TestSyncedBy(t *testing.T) {
+	meta := &metav1.ObjectMeta{}
+	addSyncedBy(meta, "ns/b")
+	addSyncedBy(meta, "ns/a")
+	addSyncedBy(meta, "ns/b")
+	assert.Equal(t, "ns/a,ns/b", meta.Annotations[SyncedByAnnotation])
+
+	assert.False(t, removeSyncedBy(meta, "ns/c"))
+	assert.True(t, removeSyncedBy(meta, "ns/a"))
+	assert.Equal(t, "ns/b", meta.Annotations[SyncedByAnnotation])
+	assert.True(t, removeSyncedBy(meta, "ns/b"))
+	assert.NotContains(t, meta.Annotations, SyncedByAnnotation)
+}
@@ -0,0 +1,46 @@
+package sync
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxReportedUserErrors limits the number of users listed in the message of UserErrors
+const maxReportedUserErrors = 5
+
+// UserError is returned if an OpenShift user could not be updated
+type UserError struct {
+	Username string
+	Err      error
+}
+
+func (e *UserError) Error() string {
+	return fmt.Sprintf("user %q: %s", e.Username, e.Err)
+}
+
+func (e *UserError) Unwrap() error {
+	return e.Err
+}
+
+// UserErrors is returned if some OpenShift users could not be updated. All other users were synced nonetheless.
+type UserErrors []*UserError
+
+func (e UserErrors) Error() string {
+	msgs := make([]string, 0, maxReportedUserErrors)
+	for i, err := range e {
+		if i == maxReportedUserErrors {
+			msgs = append(msgs, fmt.Sprintf("and %d more", len(e)-maxReportedUserErrors))
+			break
+		}
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("failed updating %d users: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// sort orders the errors by username, so the message doesn't depend on the order the users were updated in.
+func (e UserErrors) sort() {
+	sort.Slice(e, func(i, j int) bool {
+		return e[i].Username < e[j].Username
+	})
+}
@@ -0,0 +1,35 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/Nerzal/gocloak/v9"
+)
+
+// Fingerprints maps Keycloak usernames to a hash of the values last written to the OpenShift user.
+type Fingerprints map[string]string
+
+// retain removes the fingerprints of all users not in the given list.
+func (f Fingerprints) retain(users []*gocloak.User) {
+	keep := make(map[string]bool, len(users))
+	for _, user := range users {
+		if user.Username != nil {
+			keep[*user.Username] = true
+		}
+	}
+	for username := range f {
+		if !keep[username] {
+			delete(f, username)
+		}
+	}
+}
+
+func fingerprint(attribute, targetLabel, targetAnnotation string) string {
+	h := sha256.New()
+	for _, s := range []string{targetLabel, targetAnnotation, attribute} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,46 @@
+package sync
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	resultFetched = "fetched"
+	resultUpdated = "updated"
+	resultSkipped = "skipped"
+	resultFailed  = "failed"
+)
+
+var (
+	usersTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keycloak_attribute_sync_users_total",
+		Help: "Number of users processed by synchronizations, by result.",
+	}, []string{"namespace", "name", "result"})
+
+	driftedUsers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "keycloak_attribute_sync_drifted_users",
+		Help: "Number of users whose existing label or annotation differed from Keycloak in the last synchronization.",
+	}, []string{"namespace", "name"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(usersTotal, driftedUsers)
+}
+
+func (u *UserSyncer) observeUsers(stats Stats) {
+	ns, name := u.Owner.Namespace, u.Owner.Name
+	usersTotal.WithLabelValues(ns, name, resultFetched).Add(float64(stats.Fetched))
+	usersTotal.WithLabelValues(ns, name, resultUpdated).Add(float64(stats.Updated))
+	usersTotal.WithLabelValues(ns, name, resultFailed).Add(float64(stats.Failed))
+	usersTotal.WithLabelValues(ns, name, resultSkipped).Add(float64(stats.Skipped))
+}
+
+// DeleteMetrics removes the metrics of the given AttributeSync.
+func DeleteMetrics(owner types.NamespacedName) {
+	for _, result := range []string{resultFetched, resultUpdated, resultSkipped, resultFailed} {
+		usersTotal.DeleteLabelValues(owner.Namespace, owner.Name, result)
+	}
+	driftedUsers.DeleteLabelValues(owner.Namespace, owner.Name)
+}
@@ -0,0 +1,76 @@
+package sync
+
+import (
+	"encoding/json"
+
+	"github.com/Nerzal/gocloak/v9"
+)
+
+const (
+	// ReportUpdated is the result of users whose OpenShift user was updated
+	ReportUpdated = "Updated"
+	// ReportPlanned is the result of users whose OpenShift user would have been updated without a dry run
+	ReportPlanned = "Planned"
+	// ReportSkipped is the result of users which were not synced
+	ReportSkipped = "Skipped"
+	// ReportFailed is the result of users whose OpenShift user could not be updated
+	ReportFailed = "Failed"
+)
+
+// Report lists the outcome of a synchronization per Keycloak user.
+// Only the first users up to the maximum number of users and the maximum size are listed, Total counts all users.
+type Report struct {
+	Users []ReportEntry `json:"users"`
+	Total int           `json:"total"`
+	// Truncated is true if not all users are listed
+	Truncated bool `json:"truncated,omitempty"`
+
+	maxUsers int
+	maxSize  int
+	size     int
+}
+
+// ReportEntry is the outcome of the synchronization of a single Keycloak user
+type ReportEntry struct {
+	// Username is the name of the Keycloak user
+	Username string `json:"username"`
+	// OpenShiftUser is the name of the matched OpenShift user, empty if none was found
+	OpenShiftUser string `json:"openshiftUser,omitempty"`
+	// Value is the value of the attribute
+	Value string `json:"value,omitempty"`
+	// Result is one of Updated, Planned, Skipped or Failed
+	Result string `json:"result"`
+	// Reason explains why the user was skipped or failed
+	Reason string `json:"reason,omitempty"`
+}
+
+// NewReport returns an empty report listing at most maxUsers users, whose serialized entries take at most maxSize bytes.
+// A maxSize of zero or less doesn't limit the size.
+func NewReport(maxUsers, maxSize int) *Report {
+	return &Report{Users: []ReportEntry{}, maxUsers: maxUsers, maxSize: maxSize}
+}
+
+func (r *Report) add(user *gocloak.User, entry ReportEntry) {
+	if r == nil {
+		return
+	}
+	r.Total++
+	if r.Truncated {
+		return
+	}
+	if len(r.Users) >= r.maxUsers {
+		r.Truncated = true
+		return
+	}
+	entry.Username = gocloak.PString(user.Username)
+	if r.maxSize > 0 {
+		data, err := json.Marshal(entry)
+		// Plus a separating comma
+		if err != nil || r.size+len(data)+1 > r.maxSize {
+			r.Truncated = true
+			return
+		}
+		r.size += len(data) + 1
+	}
+	r.Users = append(r.Users, entry)
+}
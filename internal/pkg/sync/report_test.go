@@ -0,0 +1,48 @@
+package sync
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport_MaxUsers(t *testing.T) {
+	report := NewReport(2, 0)
+	for _, name := range []string{"a", "b", "c"} {
+		report.add(&gocloak.User{Username: gocloak.StringP(name)}, ReportEntry{Result: ReportUpdated})
+	}
+
+	assert.Equal(t, 3, report.Total)
+	assert.True(t, report.Truncated)
+	require.Len(t, report.Users, 2)
+	assert.Equal(t, "a", report.Users[0].Username)
+	assert.Equal(t, "b", report.Users[1].Username)
+}
+
+func TestReport_MaxSize(t *testing.T) {
+	report := NewReport(1000, 4096)
+	value := strings.Repeat("x", 500)
+	for i := 0; i < 100; i++ {
+		report.add(&gocloak.User{Username: gocloak.StringP("user")}, ReportEntry{Value: value, Result: ReportUpdated})
+	}
+
+	assert.Equal(t, 100, report.Total)
+	assert.True(t, report.Truncated)
+	assert.NotEmpty(t, report.Users)
+	data, err := json.Marshal(report.Users)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(data), 4096)
+}
+
+func TestReport_NotTruncated(t *testing.T) {
+	report := NewReport(10, 4096)
+	report.add(&gocloak.User{Username: gocloak.StringP("a")}, ReportEntry{Result: ReportSkipped, Reason: "user has no attributes"})
+
+	assert.Equal(t, 1, report.Total)
+	assert.False(t, report.Truncated)
+	assert.Len(t, report.Users, 1)
+}
@@ -0,0 +1,141 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Nerzal/gocloak/v9"
+	userv1 "github.com/openshift/api/user/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/keycloak"
+)
+
+// ReverseSync writes the value of the source label or annotation of every OpenShift user to the attribute of the Keycloak user with the same name.
+// Only one of sourceLabel and sourceAnnotation may be set. Users without the label or annotation are skipped, the attribute is never removed.
+// Every Keycloak user is fetched again right before it is updated, so attributes changed in the meantime are not reverted.
+// Errors caused by Keycloak being unavailable are returned as is, not as UserErrors, so they are not mistaken for invalid users.
+func (u *UserSyncer) ReverseSync(ctx context.Context, realm, sourceLabel, sourceAnnotation, attribute string) error {
+	ocpUsers := &userv1.UserList{}
+	if err := u.K8sClient.List(ctx, ocpUsers); err != nil {
+		return fmt.Errorf("error listing users: %w", err)
+	}
+	values := make(map[string]string, len(ocpUsers.Items))
+	for _, user := range ocpUsers.Items {
+		var value string
+		var ok bool
+		if sourceAnnotation != "" {
+			value, ok = user.Annotations[sourceAnnotation]
+		} else {
+			value, ok = user.Labels[sourceLabel]
+		}
+		if ok {
+			values[user.Name] = value
+		}
+	}
+
+	// A single token is used for the whole pass, as every changed user takes two requests
+	return u.KeycloakClient.Session(ctx, func(kc keycloak.Client) error {
+		return u.reverseSync(ctx, kc, realm, values, attribute)
+	})
+}
+
+// reverseSync writes the given values by username to the attribute of the Keycloak users.
+// It stops at the first error caused by Keycloak being unavailable, which would fail for all other users as well.
+func (u *UserSyncer) reverseSync(ctx context.Context, kc keycloak.Client, realm string, values map[string]string, attribute string) error {
+	l := log.FromContext(ctx)
+
+	users, err := kc.GetUsers(ctx, realm, gocloak.GetUsersParams{
+		Max: gocloak.IntP(-1),
+	})
+	if err != nil {
+		return fmt.Errorf("error fetching users: %w", err)
+	}
+
+	updated := 0
+	var userErrs UserErrors
+	for _, user := range users {
+		if user.Username == nil {
+			continue
+		}
+		value, ok := values[*user.Username]
+		if !ok {
+			continue
+		}
+		oldValue, changed := reverseChange(user, attribute, value)
+		if !changed {
+			continue
+		}
+
+		if u.DryRun {
+			u.planChange(*user.Username, "KeycloakAttribute", attribute, oldValue, value)
+			continue
+		}
+
+		// The user is replaced as a whole, base the update on its current representation
+		current, err := kc.GetUserByID(ctx, realm, gocloak.PString(user.ID))
+		if err != nil {
+			if keycloak.IsNotFound(err) {
+				continue
+			}
+			if keycloakUnavailable(err) {
+				return fmt.Errorf("error fetching user %q: %w", *user.Username, err)
+			}
+			l.Error(err, "unable to fetch keycloak user", "username", *user.Username)
+			userErrs = append(userErrs, &UserError{Username: *user.Username, Err: err})
+			continue
+		}
+		oldValue, changed = reverseChange(current, attribute, value)
+		if !changed {
+			continue
+		}
+		attributes := map[string][]string{}
+		if current.Attributes != nil {
+			for k, v := range *current.Attributes {
+				attributes[k] = v
+			}
+		}
+		attributes[attribute] = []string{value}
+
+		// Send the full representation, Keycloak may clear fields missing in the update, like the email or name with the declarative user profile
+		update := *current
+		update.Attributes = &attributes
+		if err := kc.UpdateUser(ctx, realm, update); err != nil {
+			if keycloakUnavailable(err) {
+				return fmt.Errorf("error updating user %q: %w", *user.Username, err)
+			}
+			l.Error(err, "unable to update keycloak user", "username", *user.Username)
+			userErrs = append(userErrs, &UserError{Username: *user.Username, Err: err})
+			continue
+		}
+		if u.RecordChanges {
+			u.planChange(*user.Username, "KeycloakAttribute", attribute, oldValue, value)
+		}
+		updated++
+	}
+
+	l.Info("Reverse synced users", "updated", updated, "failed", len(userErrs))
+	if len(userErrs) > 0 {
+		return userErrs
+	}
+	return nil
+}
+
+// keycloakUnavailable returns true if the error is caused by Keycloak being unreachable or rejecting the credentials, as opposed to an invalid user.
+func keycloakUnavailable(err error) bool {
+	var loginErr *keycloak.LoginError
+	return keycloak.IsTransient(err) || keycloak.IsTLSError(err) || errors.As(err, &loginErr)
+}
+
+// reverseChange returns the current value of the attribute of the Keycloak user and whether it differs from the given value.
+func reverseChange(user *gocloak.User, attribute, value string) (string, bool) {
+	if user.Attributes == nil {
+		return "", true
+	}
+	old := (*user.Attributes)[attribute]
+	if len(old) == 0 {
+		return "", true
+	}
+	return old[0], len(old) != 1 || old[0] != value
+}
@@ -0,0 +1,116 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v9"
+	userv1 "github.com/openshift/api/user/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/keycloak"
+)
+
+const testReverseAttribute = "example.com/department"
+
+// sessionClient counts the sessions and fails fetching users with getErr and updates with updateErr, if set
+type sessionClient struct {
+	*keycloak.FakeClient
+	sessions  int
+	inSession bool
+	getErr    error
+	updateErr error
+}
+
+func (c *sessionClient) Session(ctx context.Context, f func(c keycloak.Client) error) error {
+	c.sessions++
+	c.inSession = true
+	defer func() { c.inSession = false }()
+	return f(c)
+}
+
+func (c *sessionClient) GetUserByID(ctx context.Context, realm, userID string) (*gocloak.User, error) {
+	if c.getErr != nil {
+		return nil, c.getErr
+	}
+	return c.FakeClient.GetUserByID(ctx, realm, userID)
+}
+
+func (c *sessionClient) UpdateUser(ctx context.Context, realm string, user gocloak.User) error {
+	if !c.inSession {
+		return errors.New("update outside of the session")
+	}
+	if c.updateErr != nil {
+		return c.updateErr
+	}
+	return c.FakeClient.UpdateUser(ctx, realm, user)
+}
+
+func newReverseTestSyncer(t *testing.T) (*UserSyncer, *sessionClient) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, userv1.AddToScheme(scheme))
+
+	kc := &sessionClient{FakeClient: &keycloak.FakeClient{}}
+	objs := []runtime.Object{}
+	for _, name := range []string{"alice", "bob"} {
+		user := keycloak.UserWithAttribute(name, testAttribute, "IgniteCyber")
+		user.ID = gocloak.StringP(name)
+		user.Email = gocloak.StringP(name + "@example.com")
+		user.FirstName = gocloak.StringP(name)
+		kc.Users = append(kc.Users, user)
+		objs = append(objs, &userv1.User{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{testLabel: "Engineering"}}})
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...).Build()
+	return &UserSyncer{KeycloakClient: kc, K8sClient: c}, kc
+}
+
+func TestReverseSync(t *testing.T) {
+	syncer, kc := newReverseTestSyncer(t)
+
+	require.NoError(t, syncer.ReverseSync(context.Background(), "realm", testLabel, "", testReverseAttribute))
+
+	assert.Equal(t, 1, kc.sessions, "all users are updated with a single session")
+	for _, user := range kc.Users {
+		assert.Equal(t, []string{"Engineering"}, (*user.Attributes)[testReverseAttribute])
+		assert.Equal(t, []string{"IgniteCyber"}, (*user.Attributes)[testAttribute], "other attributes are kept")
+		assert.Equal(t, *user.Username+"@example.com", gocloak.PString(user.Email), "the full representation is sent")
+		assert.Equal(t, *user.Username, gocloak.PString(user.FirstName), "the full representation is sent")
+	}
+}
+
+func TestReverseSync_KeycloakUnavailable(t *testing.T) {
+	syncer, kc := newReverseTestSyncer(t)
+	kc.updateErr = &gocloak.APIError{Code: http.StatusServiceUnavailable, Message: "503 Service Unavailable"}
+
+	err := syncer.ReverseSync(context.Background(), "realm", testLabel, "", testReverseAttribute)
+
+	require.Error(t, err)
+	var userErrs UserErrors
+	assert.False(t, errors.As(err, &userErrs), "an unavailable Keycloak must not be reported as failed users")
+	assert.True(t, keycloak.IsTransient(err))
+}
+
+func TestReverseSync_UserDeleted(t *testing.T) {
+	syncer, kc := newReverseTestSyncer(t)
+	kc.getErr = fmt.Errorf("request failed: %w", &gocloak.APIError{Code: http.StatusNotFound, Message: "404 Not Found"})
+
+	require.NoError(t, syncer.ReverseSync(context.Background(), "realm", testLabel, "", testReverseAttribute), "users deleted in the meantime are skipped")
+}
+
+func TestReverseSync_UserRejected(t *testing.T) {
+	syncer, kc := newReverseTestSyncer(t)
+	kc.updateErr = &gocloak.APIError{Code: http.StatusBadRequest, Message: "400 Bad Request"}
+
+	err := syncer.ReverseSync(context.Background(), "realm", testLabel, "", testReverseAttribute)
+
+	var userErrs UserErrors
+	require.True(t, errors.As(err, &userErrs))
+	assert.Len(t, userErrs, 2)
+}
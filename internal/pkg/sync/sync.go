@@ -3,12 +3,19 @@ package sync
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
+	gosync "sync"
 	"time"
 
 	userv1 "github.com/openshift/api/user/v1"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
@@ -16,9 +23,98 @@ import (
 	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/keycloak"
 )
 
+// SyncTimeAnnotation is set on every synced OpenShift user to the time of the synchronization
+const SyncTimeAnnotation = "attributesync.keycloak.appuio.io/sync-time"
+
+// SyncedByAnnotation lists the AttributeSyncs and ClusterAttributeSyncs which synced the OpenShift user, separated by commas.
+// The cleanup of a deleted instance only touches the users it synced.
+const SyncedByAnnotation = "attributesync.keycloak.appuio.io/synced-by"
+
 type UserSyncer struct {
 	KeycloakClient keycloak.Client
-	K8sClient      client.Client
+	// K8sClient should read OpenShift users from the informer cache, only updates are sent to the API server.
+	K8sClient client.Client
+	// APIReader, if not nil, reads OpenShift users from the API server when an update is retried after a conflict,
+	// as the informer cache of K8sClient usually still holds the outdated version.
+	APIReader client.Reader
+
+	// Workers is the number of OpenShift users updated in parallel. Zero or one updates them sequentially.
+	Workers int
+	// UpdateLimiter, if not nil, limits the rate of OpenShift user updates. It can be shared by several UserSyncers.
+	UpdateLimiter *rate.Limiter
+
+	// Fingerprints, if not nil, is updated with the fingerprint of every synced user.
+	Fingerprints Fingerprints
+	// SkipUnchanged skips users whose fingerprint did not change since they were last synced and whose OpenShift user still has the synced values.
+	// Only users of a full synchronization can be skipped, as the others are not listed with their OpenShift users.
+	SkipUnchanged bool
+
+	// Owner is the AttributeSync the synchronization belongs to, used to label metrics
+	Owner types.NamespacedName
+
+	// ValueRecorder, if not nil, is notified of the attribute value of every Keycloak user before the OpenShift user is updated.
+	ValueRecorder ValueRecorder
+	// EventRecorder, if not nil, is used to emit an event on every OpenShift user whose label or annotation changes.
+	EventRecorder record.EventRecorder
+
+	// DryRun records the changes in PlannedChanges instead of updating the OpenShift users.
+	DryRun bool
+	// RecordChanges records the applied changes in PlannedChanges as well, so they can be printed after the synchronization.
+	RecordChanges bool
+
+	// Report, if not nil, is updated with the outcome of every Keycloak user.
+	Report *Report
+
+	stats   Stats
+	planned []PlannedChange
+}
+
+// PlannedChange is a change to a label or annotation of an OpenShift user which was not applied because of a dry run,
+// or which was applied if changes are recorded
+type PlannedChange struct {
+	User     string `json:"user"`
+	Kind     string `json:"kind"`
+	Key      string `json:"key"`
+	OldValue string `json:"oldValue"`
+	NewValue string `json:"newValue"`
+	Action   string `json:"action"`
+}
+
+// PlannedChanges returns the changes recorded by a dry run, or the applied changes if RecordChanges is set
+func (u *UserSyncer) PlannedChanges() []PlannedChange {
+	return u.planned
+}
+
+// Stats counts the outcomes of all synchronizations run by a UserSyncer
+type Stats struct {
+	// Fetched is the number of users fetched from Keycloak
+	Fetched int
+	// Updated is the number of OpenShift users updated, always zero in a dry run
+	Updated int
+	// Skipped is the number of users without attribute, unchanged fingerprint or OpenShift user, or all users in a dry run
+	Skipped int
+	// Failed is the number of OpenShift users which could not be updated
+	Failed int
+	// Drifted is the number of OpenShift users whose existing label or annotation differed from Keycloak and was overwritten
+	Drifted int
+}
+
+func (s *Stats) add(o Stats) {
+	s.Fetched += o.Fetched
+	s.Updated += o.Updated
+	s.Skipped += o.Skipped
+	s.Failed += o.Failed
+	s.Drifted += o.Drifted
+}
+
+// Stats returns the counts of all synchronizations run by the UserSyncer
+func (u *UserSyncer) Stats() Stats {
+	return u.stats
+}
+
+// ValueRecorder records the attribute values of Keycloak users
+type ValueRecorder interface {
+	RecordValue(username, value string)
 }
 
 func (u *UserSyncer) Sync(ctx context.Context, realm, attribute, targetLabel, targetAnnotation string) error {
@@ -29,71 +125,392 @@ func (u *UserSyncer) Sync(ctx context.Context, realm, attribute, targetLabel, ta
 		return fmt.Errorf("error fetching users: %w", err)
 	}
 
-	err = u.syncUsers(ctx, users, attribute, targetLabel, targetAnnotation)
+	ocpUsers, err := u.userIndex(ctx)
+	if err != nil {
+		return err
+	}
+	stats, err := u.syncUsers(ctx, users, ocpUsers, attribute, targetLabel, targetAnnotation)
+	if err != nil {
+		return fmt.Errorf("error syncing users: %w", err)
+	}
+	driftedUsers.WithLabelValues(u.Owner.Namespace, u.Owner.Name).Set(float64(stats.Drifted))
+	if u.Fingerprints != nil {
+		u.Fingerprints.retain(users)
+	}
+	return nil
+}
+
+// SyncByID syncs the Keycloak users with the given IDs. Users no longer existing in Keycloak are skipped.
+func (u *UserSyncer) SyncByID(ctx context.Context, realm string, userIDs []string, attribute, targetLabel, targetAnnotation string) error {
+	users := make([]*gocloak.User, 0, len(userIDs))
+	for _, id := range userIDs {
+		user, err := u.KeycloakClient.GetUserByID(ctx, realm, id)
+		if err != nil {
+			if keycloak.IsNotFound(err) {
+				log.FromContext(ctx).V(1).Info("keycloak user not found - skipping", "userid", id)
+				continue
+			}
+			return fmt.Errorf("error fetching user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	_, err := u.syncUsers(ctx, users, nil, attribute, targetLabel, targetAnnotation)
 	if err != nil {
 		return fmt.Errorf("error syncing users: %w", err)
 	}
 	return nil
 }
 
-func (u *UserSyncer) syncUsers(ctx context.Context, users []*gocloak.User, attributeKey, targetLabel, targetAnnotation string) error {
+// SyncUser syncs the Keycloak user with the given username. The user is skipped if it does not exist in Keycloak.
+func (u *UserSyncer) SyncUser(ctx context.Context, realm, username, attribute, targetLabel, targetAnnotation string) error {
+	found, err := u.KeycloakClient.GetUsers(ctx, realm, gocloak.GetUsersParams{
+		Username: &username,
+		Exact:    gocloak.BoolP(true),
+	})
+	if err != nil {
+		return fmt.Errorf("error fetching user: %w", err)
+	}
+
+	// Older Keycloak versions ignore `exact` and return all users containing the username
+	users := make([]*gocloak.User, 0, 1)
+	for _, user := range found {
+		if user.Username != nil && *user.Username == username {
+			users = append(users, user)
+		}
+	}
+
+	_, err = u.syncUsers(ctx, users, nil, attribute, targetLabel, targetAnnotation)
+	if err != nil {
+		return fmt.Errorf("error syncing user: %w", err)
+	}
+	return nil
+}
+
+// userIndex lists all OpenShift users once and indexes them by name, which is matched against the Keycloak username.
+// The name is the only match field, as the OAuth server names OpenShift users after the preferred username of the identity,
+// and new users, reverse synchronizations and the cleanup all look users up by name as well.
+func (u *UserSyncer) userIndex(ctx context.Context) (map[string]*userv1.User, error) {
+	list := &userv1.UserList{}
+	if err := u.K8sClient.List(ctx, list); err != nil {
+		return nil, fmt.Errorf("error listing users: %w", err)
+	}
+	index := make(map[string]*userv1.User, len(list.Items))
+	for i := range list.Items {
+		index[list.Items[i].Name] = &list.Items[i]
+	}
+	return index, nil
+}
+
+// syncUsers updates the OpenShift users of the given Keycloak users.
+// If ocpUsers is not nil, it must contain all OpenShift users by name and only the Keycloak users found in it are updated.
+// Otherwise every OpenShift user is fetched separately, which is cheaper for a few users.
+func (u *UserSyncer) syncUsers(ctx context.Context, users []*gocloak.User, ocpUsers map[string]*userv1.User, attributeKey, targetLabel, targetAnnotation string) (stats Stats, err error) {
 	l := log.FromContext(ctx)
 	l.Info("Syncing users", "count", len(users))
 	syncedCount := 0
+	unchangedCount := 0
+	var userErrs UserErrors
+	stats.Fetched = len(users)
+	defer func() {
+		stats.Skipped = stats.Fetched - stats.Updated - stats.Failed
+		u.stats.add(stats)
+		u.observeUsers(stats)
+	}()
 
+	// The users to update are selected first, updated in parallel and the results processed in the order of the Keycloak users.
+	type update struct {
+		user    *gocloak.User
+		ocpUser *userv1.User
+		// missing is true if the user is not in the listed OpenShift users
+		missing   bool
+		attribute string
+		fp        string
+		res       updateResult
+		err       error
+	}
+	updates := make([]update, 0, len(users))
 	for _, user := range users {
 		l := l.WithValues("userid", user.ID, "username", user.Username)
+		if user.Username == nil {
+			// Without a username, there is no OpenShift user to match
+			l.Info("user has no username - skipping")
+			u.Report.add(user, ReportEntry{Result: ReportSkipped, Reason: "user has no username"})
+			continue
+		}
 		if user.Attributes == nil {
 			l.V(1).Info("user has no attributes - skipping")
+			u.Report.add(user, ReportEntry{Result: ReportSkipped, Reason: "user has no attributes"})
 			continue
 		}
 		attributes, ok := (*user.Attributes)[attributeKey]
 		if !ok || len(attributes) < 1 {
 			l.V(1).Info("user has no attribute - skipping", "attribute", attributeKey)
+			u.Report.add(user, ReportEntry{Result: ReportSkipped, Reason: fmt.Sprintf("user has no attribute %q", attributeKey)})
 			continue
 		}
 		attribute := attributes[0]
+		if u.ValueRecorder != nil {
+			u.ValueRecorder.RecordValue(*user.Username, attribute)
+		}
 
-		err := u.setAttributeOnUser(ctx, types.NamespacedName{Name: *user.Username}, attribute, targetLabel, targetAnnotation)
-		if err != nil {
-			return err
+		fp := fingerprint(attribute, targetLabel, targetAnnotation)
+		up := update{user: user, attribute: attribute, fp: fp}
+		if ocpUsers != nil {
+			up.ocpUser = ocpUsers[*user.Username]
+		}
+		// The fingerprint alone doesn't notice values changed by hand, the OpenShift user must still have the synced values
+		if u.SkipUnchanged && u.Fingerprints[*user.Username] == fp && u.upToDate(up.ocpUser, attribute, targetLabel, targetAnnotation) {
+			l.V(1).Info("user attribute unchanged - skipping")
+			u.Report.add(user, ReportEntry{OpenShiftUser: *user.Username, Value: attribute, Result: ReportSkipped, Reason: "attribute unchanged since last synchronization"})
+			unchangedCount++
+			continue
+		}
+		if ocpUsers != nil && up.ocpUser == nil {
+			l.V(1).Info("no OCP user object found - skipping")
+			up.missing = true
+		}
+		updates = append(updates, up)
+	}
+
+	u.forEach(len(updates), func(i int) {
+		up := &updates[i]
+		if up.missing {
+			// Reported as not found below, without a request to the API server
+			return
+		}
+		up.res, up.err = u.setAttributeOnUser(ctx, types.NamespacedName{Name: *up.user.Username}, up.ocpUser, up.attribute, targetLabel, targetAnnotation)
+	})
+
+	for _, up := range updates {
+		user, attribute, res := up.user, up.attribute, up.res
+		if up.err != nil {
+			l.Error(up.err, "unable to sync user", "userid", user.ID, "username", user.Username)
+			u.Report.add(user, ReportEntry{OpenShiftUser: *user.Username, Value: attribute, Result: ReportFailed, Reason: up.err.Error()})
+			stats.Failed++
+			userErrs = append(userErrs, &UserError{Username: *user.Username, Err: up.err})
+			continue
+		}
+		u.planned = append(u.planned, res.planned...)
+		switch {
+		case !res.found:
+			u.Report.add(user, ReportEntry{Value: attribute, Result: ReportSkipped, Reason: "no OpenShift user found"})
+		case u.DryRun:
+			u.Report.add(user, ReportEntry{OpenShiftUser: *user.Username, Value: attribute, Result: ReportPlanned})
+		default:
+			u.Report.add(user, ReportEntry{OpenShiftUser: *user.Username, Value: attribute, Result: ReportUpdated})
+			stats.Updated++
+			if res.drifted {
+				stats.Drifted++
+			}
+			if u.Fingerprints != nil {
+				u.Fingerprints[*user.Username] = up.fp
+			}
 		}
 		syncedCount++
 	}
 
-	l.Info("Synced users", "synced", syncedCount, "skipped", len(users)-syncedCount)
-	return nil
+	l.Info("Synced users", "synced", syncedCount, "unchanged", unchangedCount, "failed", len(userErrs), "skipped", len(users)-syncedCount-unchangedCount-len(userErrs))
+	if len(userErrs) > 0 {
+		userErrs.sort()
+		return stats, userErrs
+	}
+	return stats, nil
 }
 
-func (u *UserSyncer) setAttributeOnUser(ctx context.Context, key types.NamespacedName, attribute, targetLabel, targetAnnotation string) error {
+// upToDate returns whether the OpenShift user has the target label and annotation set to the attribute and is marked as synced by the Owner.
+// A nil user is never up to date, as its values are unknown.
+func (u *UserSyncer) upToDate(ocpUser *userv1.User, attribute, targetLabel, targetAnnotation string) bool {
+	if ocpUser == nil {
+		return false
+	}
+	if targetLabel != "" && ocpUser.Labels[targetLabel] != attribute {
+		return false
+	}
+	if targetAnnotation != "" && ocpUser.Annotations[targetAnnotation] != attribute {
+		return false
+	}
+	if u.Owner != (types.NamespacedName{}) {
+		owners := syncedBy(&ocpUser.ObjectMeta)
+		i := sort.SearchStrings(owners, u.Owner.String())
+		return i < len(owners) && owners[i] == u.Owner.String()
+	}
+	return true
+}
+
+// forEach calls f for every index below n, using up to Workers goroutines.
+func (u *UserSyncer) forEach(n int, f func(i int)) {
+	workers := u.Workers
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+
+	indexes := make(chan int)
+	var wg gosync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// waitForUpdate blocks until the UpdateLimiter allows another update of an OpenShift user.
+func (u *UserSyncer) waitForUpdate(ctx context.Context) error {
+	if u.UpdateLimiter == nil {
+		return nil
+	}
+	return u.UpdateLimiter.Wait(ctx)
+}
+
+// ApplyValue sets the given value on the OpenShift user without querying Keycloak and returns whether the user was found.
+func (u *UserSyncer) ApplyValue(ctx context.Context, username, value, targetLabel, targetAnnotation string) (bool, error) {
+	res, err := u.setAttributeOnUser(ctx, types.NamespacedName{Name: username}, nil, value, targetLabel, targetAnnotation)
+	u.planned = append(u.planned, res.planned...)
+	return res.found, err
+}
+
+// updateResult describes the outcome of updating a single OpenShift user
+type updateResult struct {
+	// found is false if no OpenShift user exists for the Keycloak user
+	found bool
+	// drifted is true if an existing label or annotation differed from the Keycloak value
+	drifted bool
+	// planned are the changes found by a dry run, or the applied changes if they are recorded
+	planned []PlannedChange
+}
+
+// setAttributeOnUser sets the attribute on the OpenShift user. If ocpuser is nil, the user is fetched first.
+// The update is retried on conflicts, as other AttributeSyncs may update the same user concurrently.
+func (u *UserSyncer) setAttributeOnUser(ctx context.Context, key types.NamespacedName, ocpuser *userv1.User, attribute, targetLabel, targetAnnotation string) (res updateResult, err error) {
+	var reader client.Reader = u.K8sClient
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		res, err = u.trySetAttributeOnUser(ctx, reader, key, ocpuser, attribute, targetLabel, targetAnnotation)
+		// Conflicts are retried with the current version of the user, read from the API server if possible
+		ocpuser = nil
+		if u.APIReader != nil {
+			reader = u.APIReader
+		}
+		return err
+	})
+	return res, err
+}
+
+// trySetAttributeOnUser updates the OpenShift user once. If ocpuser is nil, the user is read with the given reader.
+func (u *UserSyncer) trySetAttributeOnUser(ctx context.Context, reader client.Reader, key types.NamespacedName, ocpuser *userv1.User, attribute, targetLabel, targetAnnotation string) (updateResult, error) {
 	l := log.FromContext(ctx)
 
-	ocpuser := userv1.User{}
-	err := u.K8sClient.Get(ctx, key, &ocpuser)
-	if err != nil {
-		if apierrors.IsNotFound(err) {
-			l.V(1).Info("no OCP user object found - skipping")
-			return nil
+	if ocpuser == nil {
+		ocpuser = &userv1.User{}
+		err := reader.Get(ctx, key, ocpuser)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				l.V(1).Info("no OCP user object found - skipping")
+				return updateResult{}, nil
+			}
+			return updateResult{}, fmt.Errorf("error fetching user: %w", err)
 		}
-		return fmt.Errorf("error fetching user: %w", err)
 	}
 
+	res := updateResult{found: true}
+	// Setting a label or annotation the user doesn't have yet is not drift
+	oldAnnotation, hasAnnotation := ocpuser.Annotations[targetAnnotation]
+	annotationChanged := targetAnnotation != "" && oldAnnotation != attribute
+	oldLabel, hasLabel := ocpuser.Labels[targetLabel]
+	labelChanged := targetLabel != "" && oldLabel != attribute
+	res.drifted = (annotationChanged && hasAnnotation) || (labelChanged && hasLabel)
+
 	if targetAnnotation != "" {
 		metaSetAnnotation(&ocpuser.ObjectMeta, targetAnnotation, attribute)
 	}
 	if targetLabel != "" {
 		metaSetLabel(&ocpuser.ObjectMeta, targetLabel, attribute)
 	}
-	metaSetAnnotation(&ocpuser.ObjectMeta, "attributesync.keycloak.appuio.io/sync-time", time.Now().Format(time.RFC3339Nano))
+	metaSetAnnotation(&ocpuser.ObjectMeta, SyncTimeAnnotation, time.Now().Format(time.RFC3339Nano))
+	if u.Owner != (types.NamespacedName{}) {
+		addSyncedBy(&ocpuser.ObjectMeta, u.Owner.String())
+	}
+
+	if u.DryRun || u.RecordChanges {
+		if annotationChanged {
+			res.planned = append(res.planned, plannedChange(key.Name, "Annotation", targetAnnotation, oldAnnotation, attribute))
+		}
+		if labelChanged {
+			res.planned = append(res.planned, plannedChange(key.Name, "Label", targetLabel, oldLabel, attribute))
+		}
+	}
+	if u.DryRun {
+		return res, nil
+	}
 
-	if err := u.K8sClient.Update(ctx, &ocpuser); err != nil {
+	if err := u.waitForUpdate(ctx); err != nil {
+		return updateResult{}, err
+	}
+	if err := u.K8sClient.Update(ctx, ocpuser); err != nil {
 		if apierrors.IsNotFound(err) {
-			return nil
+			return updateResult{}, nil
 		}
-		return fmt.Errorf("unable to update user: %w", err)
+		return updateResult{}, fmt.Errorf("unable to update user: %w", err)
 	}
 
-	return nil
+	if annotationChanged {
+		u.recordChange(ocpuser, "Annotation", targetAnnotation, oldAnnotation, attribute)
+	}
+	if labelChanged {
+		u.recordChange(ocpuser, "Label", targetLabel, oldLabel, attribute)
+	}
+	return res, nil
+}
+
+// planChange records a change which would have been made without a dry run, or which was applied if changes are recorded.
+func (u *UserSyncer) planChange(username, kind, key, oldValue, newValue string) {
+	u.planned = append(u.planned, plannedChange(username, kind, key, oldValue, newValue))
+}
+
+func plannedChange(username, kind, key, oldValue, newValue string) PlannedChange {
+	action := "Update"
+	switch {
+	case newValue == "":
+		action = "Remove"
+	case oldValue == "":
+		action = "Add"
+	}
+	return PlannedChange{
+		User:     username,
+		Kind:     kind,
+		Key:      key,
+		OldValue: oldValue,
+		NewValue: newValue,
+		Action:   action,
+	}
+}
+
+// recordChange emits an event on the user about a changed or removed label or annotation.
+func (u *UserSyncer) recordChange(user *userv1.User, kind, key, oldValue, newValue string) {
+	if u.EventRecorder == nil {
+		return
+	}
+	if newValue == "" {
+		u.EventRecorder.Eventf(user, corev1.EventTypeNormal, kind+"Removed",
+			"%s %q removed by AttributeSync %s", kind, key, u.Owner)
+		return
+	}
+	u.EventRecorder.Eventf(user, corev1.EventTypeNormal, kind+"Changed",
+		"%s %q changed from %q to %q by AttributeSync %s", kind, key, oldValue, newValue, u.Owner)
 }
 
 func metaSetAnnotation(meta *metav1.ObjectMeta, key, value string) {
@@ -103,6 +520,45 @@ func metaSetAnnotation(meta *metav1.ObjectMeta, key, value string) {
 	meta.Annotations[key] = value
 }
 
+// syncedBy returns the owners listed in the synced-by annotation.
+func syncedBy(meta *metav1.ObjectMeta) []string {
+	value := meta.Annotations[SyncedByAnnotation]
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// addSyncedBy adds the owner to the synced-by annotation, keeping the owners sorted.
+func addSyncedBy(meta *metav1.ObjectMeta, owner string) {
+	owners := syncedBy(meta)
+	i := sort.SearchStrings(owners, owner)
+	if i < len(owners) && owners[i] == owner {
+		return
+	}
+	owners = append(owners, "")
+	copy(owners[i+1:], owners[i:])
+	owners[i] = owner
+	metaSetAnnotation(meta, SyncedByAnnotation, strings.Join(owners, ","))
+}
+
+// removeSyncedBy removes the owner from the synced-by annotation and returns whether it was listed.
+// The annotation is removed together with the last owner.
+func removeSyncedBy(meta *metav1.ObjectMeta, owner string) bool {
+	owners := syncedBy(meta)
+	i := sort.SearchStrings(owners, owner)
+	if i == len(owners) || owners[i] != owner {
+		return false
+	}
+	owners = append(owners[:i], owners[i+1:]...)
+	if len(owners) == 0 {
+		delete(meta.Annotations, SyncedByAnnotation)
+	} else {
+		meta.Annotations[SyncedByAnnotation] = strings.Join(owners, ",")
+	}
+	return true
+}
+
 func metaSetLabel(meta *metav1.ObjectMeta, key, value string) {
 	if meta.Labels == nil {
 		meta.Labels = map[string]string{}
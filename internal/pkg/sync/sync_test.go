@@ -0,0 +1,341 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v9"
+	userv1 "github.com/openshift/api/user/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/appuio/keycloak-attribute-sync-controller/internal/pkg/keycloak"
+)
+
+const (
+	testAttribute = "example.com/organization"
+	testLabel     = "example.com/keycloak-organization"
+)
+
+// failingClient fails updates of the given users
+type failingClient struct {
+	client.Client
+	failing map[string]bool
+}
+
+func (c *failingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if c.failing[obj.GetName()] {
+		return errors.New("update rejected")
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+// newTestSyncer returns a UserSyncer with Keycloak users user-<n> for every n and OpenShift users only for those below ocpUsers.
+// The Keycloak users are listed in descending order, so sorting can be told apart from the order of the updates.
+func newTestSyncer(t *testing.T, users, ocpUsers int) (*UserSyncer, client.Client) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, userv1.AddToScheme(scheme))
+
+	kc := &keycloak.FakeClient{}
+	objs := []runtime.Object{}
+	for i := users - 1; i >= 0; i-- {
+		name := fmt.Sprintf("user-%02d", i)
+		kc.Users = append(kc.Users, keycloak.UserWithAttribute(name, testAttribute, "org-"+name))
+		if i < ocpUsers {
+			objs = append(objs, &userv1.User{ObjectMeta: metav1.ObjectMeta{Name: name}})
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...).Build()
+	return &UserSyncer{KeycloakClient: kc, K8sClient: c, Workers: 8}, c
+}
+
+func TestSync_Workers(t *testing.T) {
+	syncer, c := newTestSyncer(t, 40, 30)
+	syncer.Report = NewReport(100, 0)
+
+	require.NoError(t, syncer.Sync(context.Background(), "realm", testAttribute, testLabel, ""))
+
+	for i := 0; i < 30; i++ {
+		user := &userv1.User{}
+		name := fmt.Sprintf("user-%02d", i)
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: name}, user))
+		assert.Equal(t, "org-"+name, user.Labels[testLabel])
+		assert.Contains(t, user.Annotations, SyncTimeAnnotation)
+	}
+	assert.Equal(t, Stats{Fetched: 40, Updated: 30, Skipped: 10}, syncer.Stats(), "labeling users for the first time is not drift")
+
+	require.Len(t, syncer.Report.Users, 40)
+	for i, entry := range syncer.Report.Users {
+		name := fmt.Sprintf("user-%02d", 39-i)
+		assert.Equal(t, name, entry.Username, "the report lists the users in the order of Keycloak")
+		if 39-i < 30 {
+			assert.Equal(t, ReportUpdated, entry.Result)
+		} else {
+			assert.Equal(t, ReportSkipped, entry.Result)
+			assert.Equal(t, "no OpenShift user found", entry.Reason)
+		}
+	}
+}
+
+// countingClient counts the List and Get requests
+type countingClient struct {
+	client.Client
+	mu          gosync.Mutex
+	lists, gets int
+}
+
+func (c *countingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	c.mu.Lock()
+	c.lists++
+	c.mu.Unlock()
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *countingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	c.mu.Lock()
+	c.gets++
+	c.mu.Unlock()
+	return c.Client.Get(ctx, key, obj)
+}
+
+func TestSync_UserIndex(t *testing.T) {
+	syncer, c := newTestSyncer(t, 40, 10)
+	counting := &countingClient{Client: c}
+	syncer.K8sClient = counting
+
+	require.NoError(t, syncer.Sync(context.Background(), "realm", testAttribute, testLabel, ""))
+
+	assert.Equal(t, 1, counting.lists, "the OpenShift users are listed once")
+	assert.Zero(t, counting.gets, "users missing from the index are skipped without a request")
+	assert.Equal(t, Stats{Fetched: 40, Updated: 10, Skipped: 30}, syncer.Stats())
+}
+
+func TestSyncByID_WrappedNotFound(t *testing.T) {
+	syncer, _ := newTestSyncer(t, 1, 1)
+	syncer.KeycloakClient.(*keycloak.FakeClient).FakeClientSetError(fmt.Errorf("request failed: %w", &gocloak.APIError{Code: http.StatusNotFound}))
+
+	require.NoError(t, syncer.SyncByID(context.Background(), "realm", []string{"deleted"}, testAttribute, testLabel, ""))
+	assert.Equal(t, Stats{}, syncer.Stats())
+}
+
+func TestSync_WorkersDryRun(t *testing.T) {
+	syncer, _ := newTestSyncer(t, 20, 20)
+	syncer.DryRun = true
+
+	require.NoError(t, syncer.Sync(context.Background(), "realm", testAttribute, testLabel, ""))
+
+	changes := syncer.PlannedChanges()
+	require.Len(t, changes, 20)
+	for i, change := range changes {
+		assert.Equal(t, fmt.Sprintf("user-%02d", 19-i), change.User, "the changes are in the order of Keycloak")
+		assert.Equal(t, "Add", change.Action)
+	}
+}
+
+func TestSync_UserErrorsSorted(t *testing.T) {
+	syncer, c := newTestSyncer(t, 20, 20)
+	failing := map[string]bool{"user-03": true, "user-11": true, "user-07": true, "user-15": true}
+	syncer.K8sClient = &failingClient{Client: c, failing: failing}
+
+	err := syncer.Sync(context.Background(), "realm", testAttribute, testLabel, "")
+
+	var userErrs UserErrors
+	require.True(t, errors.As(err, &userErrs))
+	names := make([]string, 0, len(userErrs))
+	for _, e := range userErrs {
+		names = append(names, e.Username)
+	}
+	assert.Equal(t, []string{"user-03", "user-07", "user-11", "user-15"}, names)
+	assert.Equal(t, Stats{Fetched: 20, Updated: 16, Failed: 4}, syncer.Stats())
+}
+
+func TestSync_Drifted(t *testing.T) {
+	ctx := context.Background()
+	syncer, c := newTestSyncer(t, 3, 3)
+	for name, value := range map[string]string{"user-00": "org-user-00", "user-01": "ChangedByHand"} {
+		user := &userv1.User{}
+		require.NoError(t, c.Get(ctx, client.ObjectKey{Name: name}, user))
+		user.Labels = map[string]string{testLabel: value}
+		require.NoError(t, c.Update(ctx, user))
+	}
+
+	require.NoError(t, syncer.Sync(ctx, "realm", testAttribute, testLabel, ""))
+
+	// user-00 already has the value and user-02 is labeled for the first time, only user-01 drifted
+	assert.Equal(t, 1, syncer.Stats().Drifted)
+	assert.Equal(t, float64(1), testutil.ToFloat64(driftedUsers.WithLabelValues(syncer.Owner.Namespace, syncer.Owner.Name)))
+}
+
+func TestSync_SkipUnchangedRestoresHandEdits(t *testing.T) {
+	ctx := context.Background()
+	syncer, c := newTestSyncer(t, 3, 3)
+	syncer.Fingerprints = Fingerprints{}
+	require.NoError(t, syncer.Sync(ctx, "realm", testAttribute, testLabel, ""))
+
+	user := &userv1.User{}
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "user-01"}, user))
+	user.Labels[testLabel] = "ChangedByHand"
+	require.NoError(t, c.Update(ctx, user))
+
+	// A new syncer with the persisted fingerprints, as after a restart
+	restarted := &UserSyncer{KeycloakClient: syncer.KeycloakClient, K8sClient: c, Fingerprints: syncer.Fingerprints, SkipUnchanged: true}
+	require.NoError(t, restarted.Sync(ctx, "realm", testAttribute, testLabel, ""))
+
+	assert.Equal(t, Stats{Fetched: 3, Updated: 1, Skipped: 2, Drifted: 1}, restarted.Stats())
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "user-01"}, user))
+	assert.Equal(t, "org-user-01", user.Labels[testLabel])
+}
+
+func TestSync_UserWithoutUsername(t *testing.T) {
+	ctx := context.Background()
+	syncer, _ := newTestSyncer(t, 2, 2)
+	kc := syncer.KeycloakClient.(*keycloak.FakeClient)
+	kc.Users = append(kc.Users, &gocloak.User{ID: gocloak.StringP("no-username"), Attributes: &map[string][]string{testAttribute: {"org"}}})
+	recorder := &testValueRecorder{}
+	syncer.ValueRecorder = recorder
+	syncer.Fingerprints = Fingerprints{}
+	syncer.Report = NewReport(10, 0)
+
+	require.NoError(t, syncer.Sync(ctx, "realm", testAttribute, testLabel, ""))
+
+	assert.Equal(t, Stats{Fetched: 3, Updated: 2, Skipped: 1}, syncer.Stats())
+	assert.Contains(t, syncer.Report.Users, ReportEntry{Result: ReportSkipped, Reason: "user has no username"})
+	assert.Equal(t, map[string]string{"user-00": "org-user-00", "user-01": "org-user-01"}, recorder.values)
+	assert.Len(t, syncer.Fingerprints, 2)
+}
+
+// testValueRecorder records the values by username
+type testValueRecorder struct {
+	values map[string]string
+}
+
+func (r *testValueRecorder) RecordValue(username, value string) {
+	if r.values == nil {
+		r.values = map[string]string{}
+	}
+	r.values[username] = value
+}
+
+func TestSync_UpdateLimiterCancelled(t *testing.T) {
+	syncer, _ := newTestSyncer(t, 5, 5)
+	syncer.UpdateLimiter = rate.NewLimiter(rate.Every(time.Hour), 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := syncer.Sync(ctx, "realm", testAttribute, testLabel, "")
+
+	var userErrs UserErrors
+	require.True(t, errors.As(err, &userErrs))
+	assert.Len(t, userErrs, 5)
+	for _, e := range userErrs {
+		assert.True(t, errors.Is(e, context.Canceled))
+	}
+	assert.Equal(t, 0, syncer.Stats().Updated)
+}
+
+// staleCacheClient returns the users as they were when it was created, like an informer cache which didn't see the latest updates yet
+type staleCacheClient struct {
+	client.Client
+	users map[string]*userv1.User
+}
+
+func (c *staleCacheClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if user, ok := c.users[key.Name]; ok {
+		user.DeepCopyInto(obj.(*userv1.User))
+		return nil
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func TestApplyValue_ConflictRereadsFromAPIServer(t *testing.T) {
+	for name, tc := range map[string]struct {
+		apiReader bool
+		wantErr   bool
+	}{
+		"uncached reader": {apiReader: true},
+		"cache only":      {wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			syncer, c := newTestSyncer(t, 1, 1)
+			stale := &userv1.User{}
+			require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "user-00"}, stale))
+			// Another writer updates the user after the cache was filled
+			current := stale.DeepCopy()
+			current.Labels = map[string]string{"other": "value"}
+			require.NoError(t, c.Update(ctx, current))
+
+			syncer.K8sClient = &staleCacheClient{Client: c, users: map[string]*userv1.User{"user-00": stale}}
+			if tc.apiReader {
+				syncer.APIReader = c
+			}
+			_, err := syncer.ApplyValue(ctx, "user-00", "org-user-00", testLabel, "")
+
+			if tc.wantErr {
+				assert.True(t, apierrors.IsConflict(err), "expected a conflict, got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			user := &userv1.User{}
+			require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "user-00"}, user))
+			assert.Equal(t, map[string]string{"other": "value", testLabel: "org-user-00"}, user.Labels)
+		})
+	}
+}
+
+func TestWaitForUpdate(t *testing.T) {
+	syncer := &UserSyncer{UpdateLimiter: rate.NewLimiter(rate.Every(time.Hour), 1)}
+	require.NoError(t, syncer.waitForUpdate(context.Background()), "the first update is within the burst")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, syncer.waitForUpdate(ctx))
+
+	assert.NoError(t, (&UserSyncer{}).waitForUpdate(ctx), "updates are not limited without limiter")
+}
+
+func TestForEach(t *testing.T) {
+	for _, workers := range []int{0, 1, 4, 100} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			syncer := &UserSyncer{Workers: workers}
+			var mu gosync.Mutex
+			calls := map[int]int{}
+			syncer.forEach(50, func(i int) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls[i]++
+			})
+
+			require.Len(t, calls, 50)
+			for i := 0; i < 50; i++ {
+				assert.Equal(t, 1, calls[i], "index %d", i)
+			}
+		})
+	}
+}
+
+func TestUserErrors_Sort(t *testing.T) {
+	errs := UserErrors{
+		{Username: "charlie", Err: errors.New("failed")},
+		{Username: "alice", Err: errors.New("failed")},
+		{Username: "bob", Err: errors.New("failed")},
+	}
+	errs.sort()
+
+	assert.Equal(t, "alice", errs[0].Username)
+	assert.Equal(t, "bob", errs[1].Username)
+	assert.Equal(t, "charlie", errs[2].Username)
+	assert.Equal(t, `failed updating 3 users: user "alice": failed; user "bob": failed; user "charlie": failed`, errs.Error())
+}
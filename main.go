@@ -1,18 +1,26 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"os"
+	"strings"
+
+	// Embed the time zone database, so the time zones of CRON_TZ schedules don't depend on the image.
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	userv1 "github.com/openshift/api/user/v1"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -37,14 +45,60 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if _, ok := commands[os.Args[1]]; ok {
+			os.Exit(runCommand(os.Args[1], os.Args[2:]))
+		}
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var driftEvents bool
+	var clusterResourceNamespace string
+	var referencePolicy controllers.ReferencePolicy
+	var referenceAllowlist string
+	var maxConcurrentReconciles int
+	var keycloakQPS float64
+	var keycloakBurst int
+	var userUpdateWorkers int
+	var userUpdateQPS float64
+	var userUpdateBurst int
+	var shards int
+	var shardNamespace string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&driftEvents, "emit-drift-events", false,
+		"Emit an event on OpenShift users whose synced labels or annotations were changed by hand.")
+	flag.StringVar(&clusterResourceNamespace, "cluster-resource-namespace", os.Getenv("POD_NAMESPACE"),
+		"Namespace of secrets, connections and ConfigMaps of ClusterAttributeSyncs referenced without a namespace. "+
+			"Defaults to the POD_NAMESPACE environment variable.")
+	flag.StringVar(&referencePolicy.Mode, "reference-policy", controllers.ReferencePolicyDeny,
+		"Restricts the namespaces of secrets and connections referenced by AttributeSyncs and KeycloakConnections. "+
+			"One of Allow (any namespace), Deny (only the namespace of the referencing object) or Allowlist (Deny plus --reference-allowlist).")
+	flag.StringVar(&referenceAllowlist, "reference-allowlist", "",
+		"Comma separated list of namespaces which can be referenced from any namespace if --reference-policy is Allowlist.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"Number of AttributeSyncs, ClusterAttributeSyncs and OpenShift users reconciled in parallel.")
+	flag.Float64Var(&keycloakQPS, "keycloak-qps", 0,
+		"Maximum number of requests per second to a single Keycloak URL, shared by all AttributeSyncs. Zero, the default, disables the limit.")
+	flag.IntVar(&keycloakBurst, "keycloak-burst", 40,
+		"Number of requests to a single Keycloak URL which may exceed --keycloak-qps in a burst.")
+	flag.IntVar(&userUpdateWorkers, "user-update-workers", 1,
+		"Number of OpenShift users updated in parallel by a synchronization.")
+	flag.Float64Var(&userUpdateQPS, "user-update-qps", 0,
+		"Maximum number of OpenShift user updates per second, shared by all synchronizations. "+
+			"The client limits of the controller are raised by the same amount. Zero, the default, disables the limit.")
+	flag.IntVar(&userUpdateBurst, "user-update-burst", 100,
+		"Number of OpenShift user updates which may exceed --user-update-qps in a burst.")
+	flag.IntVar(&shards, "shards", 0,
+		"Number of shards the AttributeSyncs, ClusterAttributeSyncs and KeycloakConnections are distributed to. "+
+			"Every replica reconciles the objects of the shards it holds a lease for. Zero disables sharding.")
+	flag.StringVar(&shardNamespace, "shard-lease-namespace", os.Getenv("POD_NAMESPACE"),
+		"Namespace of the shard leases. Defaults to the POD_NAMESPACE environment variable.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -53,28 +107,104 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	if referenceAllowlist != "" {
+		referencePolicy.AllowedNamespaces = strings.Split(referenceAllowlist, ",")
+	}
+	if err := referencePolicy.Validate(); err != nil {
+		setupLog.Error(err, "invalid reference policy")
+		os.Exit(1)
+	}
+	if clusterResourceNamespace == "" {
+		// References of ClusterAttributeSyncs without a namespace would silently resolve to the empty namespace
+		setupLog.Error(errors.New("neither --cluster-resource-namespace nor POD_NAMESPACE is set"), "missing cluster resource namespace")
+		os.Exit(1)
+	}
+
+	keycloak.SetRateLimit(keycloakQPS, keycloakBurst)
+
+	if shards > 0 && enableLeaderElection {
+		setupLog.Info("sharding enabled, ignoring --leader-elect")
+		enableLeaderElection = false
+	}
+
+	var userUpdateLimiter *rate.Limiter
+	cfg := ctrl.GetConfigOrDie()
+	if userUpdateQPS > 0 {
+		userUpdateLimiter = rate.NewLimiter(rate.Limit(userUpdateQPS), userUpdateBurst)
+		// User updates are limited separately as requested, leave the default budget of the client for all other requests
+		cfg.QPS += float32(userUpdateQPS)
+		cfg.Burst += userUpdateBurst
+	}
+
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "0e05254e.appuio.io",
+		// ConfigMaps are only read for the AttributeSync they belong to, don't cache all of them.
+		ClientDisableCacheFor: []client.Object{&corev1.ConfigMap{}},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	var sharder *controllers.Sharder
+	if shards > 0 {
+		identity, err := os.Hostname()
+		if err != nil {
+			setupLog.Error(err, "unable to determine shard identity")
+			os.Exit(1)
+		}
+		sharder = &controllers.Sharder{
+			Client:    mgr.GetClient(),
+			Reader:    mgr.GetAPIReader(),
+			Namespace: shardNamespace,
+			Name:      "keycloak-attribute-sync",
+			Identity:  identity,
+			Shards:    shards,
+		}
+	}
+
 	if err = (&controllers.AttributeSyncReconciler{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Scheme:    mgr.GetScheme(),
+
+		KeycloakClientBuilder: keycloak.NewClient,
+
+		Recorder:                 mgr.GetEventRecorderFor("keycloak-attribute-sync-controller"),
+		DriftEvents:              driftEvents,
+		ReferencePolicy:          referencePolicy,
+		ClusterResourceNamespace: clusterResourceNamespace,
+		MaxConcurrentReconciles:  maxConcurrentReconciles,
+		UserUpdateWorkers:        userUpdateWorkers,
+		UserUpdateLimiter:        userUpdateLimiter,
+		Sharder:                  sharder,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "AttributeSync")
+		os.Exit(1)
+	}
+	if err = (&controllers.KeycloakConnectionReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 
 		KeycloakClientBuilder: keycloak.NewClient,
+		ReferencePolicy:       referencePolicy,
+		Sharder:               sharder,
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "AttributeSync")
+		setupLog.Error(err, "unable to create controller", "controller", "KeycloakConnection")
 		os.Exit(1)
 	}
+	if sharder != nil {
+		// Added after the controllers, so the sources of all controllers are registered before the first shard is claimed
+		if err := mgr.Add(sharder); err != nil {
+			setupLog.Error(err, "unable to set up sharding")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {